@@ -3,10 +3,11 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
+	"os"
 	"strconv"
 
 	"github.com/ryank157/perfAware/internal/generator"
+	"github.com/ryank157/perfAware/internal/shared"
 	"github.com/ryank157/perfAware/internal/timing"
 )
 
@@ -23,29 +24,78 @@ type GenerateTimers struct {
 func main() {
 	// var timingEnabled bool
 	// flag.BoolVar(&timingEnabled, "timing", false, "Enable timing measurements")
+	var outDir, baseName, profileFormat, profileOut, sumMethodName string
+	flag.StringVar(&outDir, "dir", ".", "Directory to write the data and answer files to")
+	flag.StringVar(&baseName, "name", "", "Base file name for the outputs (default data_<numPoints>_<spread>)")
+	var writeBinary bool
+	flag.BoolVar(&writeBinary, "binary", false, "Also write the pairs as a packed binary file (<name>.bin)")
+	flag.StringVar(&sumMethodName, "sum", shared.SumNaive.String(), "Summation method for the average: "+shared.SumMethodNames())
+	flag.StringVar(&profileFormat, "profile-format", timing.FormatText, "Profile output format: 'text', 'json' or 'csv'")
+	flag.StringVar(&profileOut, "profile-out", "", "File to write the profile to (default stdout)")
+	flag.BoolVar(&timing.GlobalProfiler.CaptureOSMetrics, "os-metrics", false, "Also record page faults, context switches and CPU time per profile block")
+	flag.BoolVar(&timing.GlobalProfiler.CapturePerfCounters, "perf", false, "Also read perf_event_open counters (IPC, branch and cache misses) per profile block, Linux only")
+	flag.BoolVar(&timing.GlobalProfiler.SubtractOverhead, "subtract-overhead", false, "Take the calibrated profiler overhead out of the profile times")
 	flag.Parse()
+
+	if err := timing.CheckProfileFormat(profileFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(shared.ExitUsage)
+	}
+	sumMethod, err := shared.ParseSumMethod(sumMethodName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(shared.ExitUsage)
+	}
+
 	spread := flag.Arg(0)
-	if spread != "uniform" && spread != "cluster" {
-		log.Fatal("Invalid spread type.  Must be 'uniform' or 'cluster'.")
+	if err := shared.CheckSpread(spread); err != nil {
+		exitWithError(err)
 	}
 
 	seed, err := strconv.Atoi(flag.Arg(1))
 	if err != nil {
-		log.Fatalf("Invalid seed.  Must be an integer: %v", err)
+		fmt.Fprintf(os.Stderr, "Invalid seed.  Must be an integer: %v\n", err)
+		os.Exit(shared.ExitUsage)
 	}
 
 	numPoints, err := strconv.Atoi(flag.Arg(2))
 	if err != nil {
-		log.Fatalf("Invalid numPoints. Must be an integer: %v", err)
+		fmt.Fprintf(os.Stderr, "Invalid numPoints. Must be an integer: %v\n", err)
+		os.Exit(shared.ExitUsage)
+	}
+
+	if baseName == "" {
+		baseName = generator.DefaultBaseName(spread, numPoints)
 	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		exitWithError(shared.IOError(err))
+	}
+	paths := generator.OutputPathsFor(outDir, baseName, writeBinary)
 
-	// Generate data + answer file as bin
+	// Generate data + answer files
 	timing.BeginProfile()
-	avgDistance := generator.GenerateDataSetAndAnswerFiles(spread, seed, numPoints)
+	avgDistance, err := generator.GenerateDataSetAndAnswerFiles(paths, spread, seed, numPoints, sumMethod)
+	if err != nil {
+		exitWithError(err)
+	}
 
 	fmt.Printf("Method: %s\n", spread)
 	fmt.Printf("Random seed: %d\n", seed)
 	fmt.Printf("Pair count: %d\n", numPoints)
+	fmt.Printf("Summation: %s\n", sumMethod)
 	fmt.Printf("Average distance: %f\n", avgDistance)
-	timing.EndAndPrintProfile()
+	fmt.Printf("Data: %s\n", paths.Data)
+	fmt.Printf("Answer: %s\n", paths.Answer)
+	fmt.Printf("Pair answers: %s\n", paths.PairAnswers)
+	if paths.Binary != "" {
+		fmt.Printf("Binary pairs: %s\n", paths.Binary)
+	}
+	if err := timing.EndAndExportProfile(profileFormat, profileOut); err != nil {
+		exitWithError(shared.IOError(err))
+	}
+}
+
+func exitWithError(err error) {
+	fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+	os.Exit(shared.ExitCode(err))
 }
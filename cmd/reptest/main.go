@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ryank157/perfAware/internal/reptest"
+	"github.com/ryank157/perfAware/internal/shared"
+	"github.com/ryank157/perfAware/internal/timing"
+	"github.com/ryank157/perfAware/internal/validator"
+)
+
+type testFunction struct {
+	Name string
+	Func func(tester *reptest.Tester, params *readParameters)
+}
+
+var testFunctions = []testFunction{
+	{"os.ReadFile", readViaReadFile},
+	{"shared.ReadEntireFile", readViaReadEntireFile},
+	{"ReadFull into preallocated buffer", readViaReadFull},
+	{"Read in chunks", readViaChunks},
+	{"mmap", readViaMmap},
+}
+
+func main() {
+	var secondsToTry uint
+	var waves int
+	var chunkSize int
+	var parse bool
+	var arenaSlabSize int
+	flag.UintVar(&secondsToTry, "seconds", 10, "Seconds without a new minimum before a test is done")
+	flag.IntVar(&waves, "waves", 1, "Number of passes over all tests, 0 to repeat forever")
+	flag.IntVar(&chunkSize, "chunk", 64*1024, "Read size for the chunked test")
+	flag.BoolVar(&parse, "parse", false, "Also test parsing the file as JSON")
+	flag.IntVar(&arenaSlabSize, "arena-slab", validator.DefaultArenaSlabSize, "Elements per slab for the arena parse test")
+	flag.Parse()
+
+	if flag.NArg() != 1 || chunkSize <= 0 || arenaSlabSize <= 0 {
+		fmt.Fprint(os.Stderr, "Usage: [-seconds N] [-waves N] [-chunk bytes] [-parse] [-arena-slab N] <file>\n")
+		os.Exit(shared.ExitUsage)
+	}
+	fileName := flag.Arg(0)
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(shared.ExitIO)
+	}
+
+	params := readParameters{
+		FileName:      fileName,
+		Buffer:        shared.AllocateBuffer(info.Size()),
+		ChunkSize:     chunkSize,
+		ArenaSlabSize: arenaSlabSize,
+	}
+
+	tests := testFunctions
+	if parse {
+		params.Source, err = os.ReadFile(fileName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(shared.ExitIO)
+		}
+		tests = append(tests, parseTestFunctions...)
+	}
+
+	cpuTimerFreq := timing.EstimateCPUFrequency()
+	testers := make([]reptest.Tester, len(tests))
+
+	for wave := 0; waves == 0 || wave < waves; wave++ {
+		for i, test := range tests {
+			tester := &testers[i]
+			fmt.Printf("\n--- %s ---\n", test.Name)
+			tester.NewTestWave(uint64(params.Buffer.Count), cpuTimerFreq, uint32(secondsToTry))
+			test.Func(tester, &params)
+		}
+	}
+}
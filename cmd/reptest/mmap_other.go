@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "github.com/ryank157/perfAware/internal/reptest"
+
+func readViaMmap(tester *reptest.Tester, params *readParameters) {
+	tester.Error("mmap is not supported on this platform")
+}
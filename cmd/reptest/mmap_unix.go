@@ -0,0 +1,42 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/ryank157/perfAware/internal/reptest"
+)
+
+// readViaMmap maps the file and touches one byte per page so every page is
+// actually faulted in.
+func readViaMmap(tester *reptest.Tester, params *readParameters) {
+	pageSize := os.Getpagesize()
+	for tester.IsTesting() {
+		file, err := os.Open(params.FileName)
+		if err != nil {
+			tester.Error(err.Error())
+			continue
+		}
+
+		tester.BeginTime()
+		data, err := syscall.Mmap(int(file.Fd()), 0, int(params.Buffer.Count), syscall.PROT_READ, syscall.MAP_PRIVATE)
+		if err == nil {
+			var sum byte
+			for offset := 0; offset < len(data); offset += pageSize {
+				sum += data[offset]
+			}
+			params.Buffer.Data[0] = sum // keep the loop from being optimized away
+			syscall.Munmap(data)
+		}
+		tester.EndTime()
+		file.Close()
+
+		if err != nil {
+			tester.Error(err.Error())
+			continue
+		}
+		tester.CountBytes(uint64(len(data)))
+	}
+}
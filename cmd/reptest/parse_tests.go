@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/ryank157/perfAware/internal/reptest"
+	"github.com/ryank157/perfAware/internal/validator"
+)
+
+// Parse tests build the Element tree from params.Source, the file read once up
+// front, so they only measure parsing and allocation.
+var parseTestFunctions = []testFunction{
+	{"ParseJSON, new(Element) per value", parseWithNew},
+	{"ParseJSON, ElementArena", parseWithArena},
+}
+
+func parseWithNew(tester *reptest.Tester, params *readParameters) {
+	for tester.IsTesting() {
+		var parser validator.Parser
+		tester.BeginTime()
+		root := parser.ParseJSON(params.Source)
+		tester.EndTime()
+
+		if parser.HadError || root == nil {
+			tester.Error("parse failed: " + parser.Errors.Error())
+			continue
+		}
+		tester.CountBytes(uint64(len(params.Source)))
+	}
+}
+
+func parseWithArena(tester *reptest.Tester, params *readParameters) {
+	arena := validator.NewElementArena(params.ArenaSlabSize)
+	for tester.IsTesting() {
+		var parser validator.Parser
+		tester.BeginTime()
+		root := parser.ParseJSONArena(params.Source, arena)
+		tester.EndTime()
+
+		if parser.HadError || root == nil {
+			tester.Error("parse failed: " + parser.Errors.Error())
+			continue
+		}
+		tester.CountBytes(uint64(len(params.Source)))
+
+		// The tree is dropped, so the next repetition reuses the slabs
+		arena.Reset()
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/ryank157/perfAware/internal/reptest"
+	"github.com/ryank157/perfAware/internal/shared"
+)
+
+type readParameters struct {
+	FileName  string
+	Buffer    shared.Buffer // preallocated to the file size
+	ChunkSize int
+
+	// For the parse tests
+	Source        []byte // the whole file
+	ArenaSlabSize int
+}
+
+func readViaReadFile(tester *reptest.Tester, params *readParameters) {
+	for tester.IsTesting() {
+		tester.BeginTime()
+		data, err := os.ReadFile(params.FileName)
+		tester.EndTime()
+
+		if err != nil {
+			tester.Error(err.Error())
+			continue
+		}
+		tester.CountBytes(uint64(len(data)))
+	}
+}
+
+func readViaReadEntireFile(tester *reptest.Tester, params *readParameters) {
+	for tester.IsTesting() {
+		tester.BeginTime()
+		buffer, err := shared.ReadEntireFile(params.FileName)
+		tester.EndTime()
+
+		if err != nil {
+			tester.Error(err.Error())
+			continue
+		}
+		tester.CountBytes(uint64(buffer.Count))
+		shared.FreeBuffer(&buffer)
+	}
+}
+
+func readViaReadFull(tester *reptest.Tester, params *readParameters) {
+	for tester.IsTesting() {
+		file, err := os.Open(params.FileName)
+		if err != nil {
+			tester.Error(err.Error())
+			continue
+		}
+
+		tester.BeginTime()
+		n, err := io.ReadFull(file, params.Buffer.Data)
+		tester.EndTime()
+		file.Close()
+
+		if err != nil {
+			tester.Error(err.Error())
+			continue
+		}
+		tester.CountBytes(uint64(n))
+	}
+}
+
+func readViaChunks(tester *reptest.Tester, params *readParameters) {
+	chunk := make([]byte, params.ChunkSize)
+	for tester.IsTesting() {
+		file, err := os.Open(params.FileName)
+		if err != nil {
+			tester.Error(err.Error())
+			continue
+		}
+
+		total := 0
+		tester.BeginTime()
+		for {
+			n, err := file.Read(chunk)
+			total += n
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				tester.Error(err.Error())
+				break
+			}
+		}
+		tester.EndTime()
+		file.Close()
+
+		tester.CountBytes(uint64(total))
+	}
+}
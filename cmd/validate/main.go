@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/ryank157/perfAware/internal/shared"
 	"github.com/ryank157/perfAware/internal/timing"
 	"github.com/ryank157/perfAware/internal/validator"
 )
@@ -14,19 +15,177 @@ func main() {
 	// Parse the flags, and note must must be called before finding flags.
 	// var timingEnabled bool
 	// flag.BoolVar(&timingEnabled, "timing", false, "Enable timing measurements")
+	var opts validator.Options
+	var profileFormat, profileOut string
+	var captureOSMetrics, capturePerfCounters, subtractOverhead bool
+	var runs int
+	flag.StringVar(&opts.Parser, "parser", validator.ParserTree, "JSON parser to use: 'tree' or 'stream'")
+	flag.StringVar(&opts.Float, "float", validator.FloatFast, "Number converter to use: 'fast', 'strconv' or 'naive'")
+	flag.BoolVar(&opts.Strict, "strict", false, "Reject input that isn't strictly RFC 8259 JSON")
+	flag.StringVar(&opts.Alloc, "alloc", validator.AllocGC, "Tree parser Element allocation: 'gc' or 'arena'")
+	flag.IntVar(&opts.ArenaSlabSize, "arena-slab", validator.DefaultArenaSlabSize, "Elements per arena slab with -alloc arena")
+	flag.StringVar(&opts.PairAnswersFileName, "pairs", "", "Per-pair answers file (<name>_pairs.f64) to check each distance against")
+	flag.IntVar(&opts.MaxMismatches, "mismatches", 10, "Number of mismatching pairs to report")
+	flag.StringVar(&opts.SumMethod, "sum", "", "Summation method ("+shared.SumMethodNames()+"), default is the one recorded in the answer file")
+	flag.IntVar(&opts.Threads, "threads", 0, "Workers for the parallel sum, 0 for the single threaded sum")
+	flag.BoolVar(&opts.SkipHash, "skip-hash", false, "Don't hash the input to check it is the data set the answer file was generated for")
+	flag.Float64Var(&opts.Tolerance, "tolerance", 1e-6, "Largest absolute difference from the reference sum that passes")
+	flag.StringVar(&profileFormat, "profile-format", timing.FormatText, "Profile output format: 'text', 'json' or 'csv'")
+	flag.StringVar(&profileOut, "profile-out", "", "File to write the profile to (default stdout)")
+	flag.BoolVar(&captureOSMetrics, "os-metrics", false, "Also record page faults, context switches and CPU time per profile block")
+	flag.BoolVar(&capturePerfCounters, "perf", false, "Also read perf_event_open counters (IPC, branch and cache misses) per profile block, Linux only")
+	flag.BoolVar(&subtractOverhead, "subtract-overhead", false, "Take the calibrated profiler overhead out of the profile times")
+	flag.IntVar(&runs, "runs", 1, "Validate this many times, each in its own profiling session, and compare the profiles")
 	flag.Parse()
 
+	if err := timing.CheckProfileFormat(profileFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(shared.ExitUsage)
+	}
+
+	if opts.Parser != validator.ParserTree && opts.Parser != validator.ParserStream {
+		fmt.Fprintf(os.Stderr, "Invalid parser %q.  Must be 'tree' or 'stream'.\n", opts.Parser)
+		os.Exit(shared.ExitUsage)
+	}
+
+	if _, err := validator.FloatConverterByName(opts.Float); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(shared.ExitUsage)
+	}
+
+	if opts.Alloc != validator.AllocGC && opts.Alloc != validator.AllocArena {
+		fmt.Fprintf(os.Stderr, "Invalid allocation mode %q.  Must be 'gc' or 'arena'.\n", opts.Alloc)
+		os.Exit(shared.ExitUsage)
+	}
+
+	if opts.ArenaSlabSize <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid arena slab size %d.  Must be 1 or more.\n", opts.ArenaSlabSize)
+		os.Exit(shared.ExitUsage)
+	}
+
+	if opts.SumMethod != "" {
+		if _, err := shared.ParseSumMethod(opts.SumMethod); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(shared.ExitUsage)
+		}
+	}
+
+	if opts.Threads < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid thread count %d.  Must be 0 or more.\n", opts.Threads)
+		os.Exit(shared.ExitUsage)
+	}
+
+	if runs < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid run count %d.  Must be 1 or more.\n", runs)
+		os.Exit(shared.ExitUsage)
+	}
+
 	if flag.NArg() != 2 {
-		fmt.Fprint(os.Stderr, "Usage: <haversine_input.json> <answers.f64> \n")
-		os.Exit(1)
+		fmt.Fprint(os.Stderr, "Usage: [-parser tree|stream] [-float fast|strconv|naive] [-strict] [-alloc gc|arena] [-arena-slab N] [-pairs <name>_pairs.f64] [-mismatches N] [-sum method] [-threads N] [-skip-hash] [-tolerance T] [-profile-format text|json|csv] [-profile-out file] [-os-metrics] [-perf] [-subtract-overhead] [-runs N] <name>.json|<name>.bin <name>.f64 \n")
+		os.Exit(shared.ExitUsage)
 	}
 	inputFileName := flag.Arg(0)
 	answersFileName := flag.Arg(1)
 
-	timing.BeginProfile()
+	// Runs share one arena, so only the first pays for the slabs
+	if opts.Alloc == validator.AllocArena {
+		opts.Arena = validator.NewElementArena(opts.ArenaSlabSize)
+	}
+
+	// Every run gets its own profiler, so the sessions can be compared
+	profiles := make([]timing.NamedProfile, 0, runs)
+	passed := true
+	var first validator.Result
+	for run := range runs {
+		profiler := timing.NewProfiler()
+		profiler.CaptureOSMetrics = captureOSMetrics
+		profiler.CapturePerfCounters = capturePerfCounters
+		profiler.SubtractOverhead = subtractOverhead
+
+		profiler.Begin()
+		result, err := validator.ValidateData(inputFileName, answersFileName, opts)
+		profiler.End()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(shared.ExitCode(err))
+		}
 
-	validator.ValidateData(inputFileName, answersFileName)
+		if run == 0 {
+			first = result
+			printResult(result)
+		} else if result.Sum != first.Sum {
+			fmt.Printf("Run %d: sum %.16f differs from the first run's %.16f\n", run+1, result.Sum, first.Sum)
+		}
+		passed = passed && result.Passed
+		profiles = append(profiles, timing.NamedProfile{Name: fmt.Sprintf("run %d", run+1), Profile: profiler.Snapshot()})
+	}
+
+	if err := writeProfiles(profiles, profileFormat, profileOut); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: writing profile: %v\n", err)
+		os.Exit(shared.ExitIO)
+	}
 
-	timing.EndAndPrintProfile()
+	if !passed {
+		os.Exit(shared.ExitValidation)
+	}
+}
+
+// writeProfiles writes a single profile as usual and several side by side, to
+// path or to stdout when path is empty.
+func writeProfiles(profiles []timing.NamedProfile, format string, path string) (err error) {
+	out := os.Stdout
+	if path != "" {
+		file, createErr := os.Create(path)
+		if createErr != nil {
+			return createErr
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		out = file
+	}
 
+	if len(profiles) == 1 {
+		return timing.WriteProfile(out, profiles[0].Profile, format)
+	}
+	return timing.WriteProfiles(out, profiles, format)
+}
+
+func printResult(result validator.Result) {
+	fmt.Printf("Input size: %d (%s)\n", result.InputBytes, result.InputFormat)
+	switch answer := result.Answer; {
+	case answer.IsLegacy():
+		fmt.Printf("Answer file: legacy\n")
+	case answer.DescribesDataSet():
+		fmt.Printf("Answer file: v%d, %d %s pairs, seed %d\n", answer.Version, answer.PairCount, answer.Spread, answer.Seed)
+	default:
+		fmt.Printf("Answer file: v%d\n", answer.Version)
+	}
+	fmt.Printf("Pair count: %d\n", result.PairCount)
+	fmt.Printf("Summation: %s\n", result.SumMethod)
+	fmt.Printf("Haversine sum: %.16f\n", result.Sum)
+
+	fmt.Printf("\nValidation:\n")
+	fmt.Printf("Reference sum: %.16f\n", result.ReferenceSum)
+	fmt.Printf("Difference   :    %.16f\n", result.Sum-result.ReferenceSum)
+	fmt.Printf("Relative     :    %.6e (%d ULP)\n", result.RelDiff, result.ULPDiff)
+	if result.Passed {
+		fmt.Printf("Result       : PASS (tolerance %g)\n", result.Tolerance)
+	} else {
+		fmt.Printf("Result       : FAIL (tolerance %g)\n", result.Tolerance)
+	}
+	fmt.Printf("\n")
+
+	if result.Pairs != nil {
+		fmt.Printf("Pair validation:\n")
+		fmt.Printf("Reference pairs: %d\n", result.Pairs.ReferenceCount)
+		fmt.Printf("Mismatches     : %d\n", result.Pairs.MismatchCount)
+		for _, m := range result.Pairs.Mismatches {
+			fmt.Printf("  [%d] (%.15f, %.15f) -> (%.15f, %.15f): got %.16f, expected %.16f, error %.6e\n",
+				m.Index, m.Pair.X0, m.Pair.Y0, m.Pair.X1, m.Pair.Y1, m.Distance, m.Reference, m.Distance-m.Reference)
+		}
+		fmt.Printf("\n")
+	}
 }
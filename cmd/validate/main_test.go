@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryank157/perfAware/internal/timing"
+)
+
+func TestWriteProfilesErrors(t *testing.T) {
+	profiles := []timing.NamedProfile{{Name: "run 1"}, {Name: "run 2"}}
+
+	if err := writeProfiles(profiles, timing.FormatText, filepath.Join(t.TempDir(), "missing", "profile.txt")); err == nil {
+		t.Error("no error creating a file in a missing directory")
+	}
+	if _, err := os.Stat("/dev/full"); err == nil {
+		for _, format := range []string{timing.FormatJSON, timing.FormatCSV} {
+			if err := writeProfiles(profiles, format, "/dev/full"); err == nil {
+				t.Errorf("%s: no error writing to a full device", format)
+			}
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "profile.txt")
+	if err := writeProfiles(profiles, timing.FormatText, path); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Errorf("profile not written: %v", err)
+	}
+}
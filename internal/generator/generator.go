@@ -3,61 +3,160 @@ package generator
 import (
 	"bufio"
 	"encoding/binary"
-	"log"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/ryank157/perfAware/internal/shared"
 )
 
-func GenerateDataSetAndAnswerFiles(spread string, seed int, numPoints int) float64 {
-	// Create files
-	binFile, err := os.Create("data.bin")
-	if err != nil {
-		log.Fatal(err)
+// OutputPaths are the files written for one generated data set.
+type OutputPaths struct {
+	Data        string // JSON pairs
+	Answer      string // answer file with the average distance
+	PairAnswers string // every pair's distance followed by the average
+	Binary      string // packed binary pairs, "" to skip
+}
+
+// DefaultBaseName names a data set after its size and spread, e.g. data_10000000_cluster.
+func DefaultBaseName(spread string, numPoints int) string {
+	return fmt.Sprintf("data_%d_%s", numPoints, spread)
+}
+
+// OutputPathsFor returns the paths for baseName inside dir. The binary pairs
+// file is only included when withBinary is set.
+func OutputPathsFor(dir string, baseName string, withBinary bool) OutputPaths {
+	base := filepath.Join(dir, baseName)
+	paths := OutputPaths{
+		Data:        base + ".json",
+		Answer:      base + ".f64",
+		PairAnswers: base + "_pairs.f64",
+	}
+	if withBinary {
+		paths.Binary = base + ".bin"
 	}
-	defer binFile.Close()
+	return paths
+}
 
-	outputFile, err := os.Create("data.json")
-	if err != nil {
-		log.Fatal(err)
+// GenerateDataSet writes the JSON data set to dataWriter, the answer file to
+// answerWriter, the per-pair distances plus the average to pairAnswersWriter
+// and, if binaryWriter isn't nil, the packed binary pairs file.
+// The average is summed with method, which is recorded in the answer file.
+func GenerateDataSet(spread string, seed int, numPoints int, method shared.SumMethod, dataWriter io.Writer, answerWriter io.Writer, pairAnswersWriter io.Writer, binaryWriter io.Writer) (float64, error) {
+	if err := shared.CheckSpread(spread); err != nil {
+		return 0, err
 	}
-	defer outputFile.Close()
 
-	bufferedWriter := bufio.NewWriter(outputFile)
-	defer bufferedWriter.Flush()
+	// Hash the files as they are written; the answer file records the hashes
+	// so the validator can tell it belongs to them (see shared.HashInput)
+	dataHash := fnv.New64a()
+	bufferedWriter := bufio.NewWriter(io.MultiWriter(dataWriter, dataHash))
+	pairAnswersBuffered := bufio.NewWriter(pairAnswersWriter)
 
-	_, err = bufferedWriter.WriteString("{\n  \"pairs\": [\n")
+	var binaryBuffered *bufio.Writer
+	binaryHash := fnv.New64a()
+	if binaryWriter != nil {
+		binaryBuffered = bufio.NewWriter(io.MultiWriter(binaryWriter, binaryHash))
+		header := shared.PairsHeader{PairCount: uint64(numPoints), Spread: spread, Seed: int64(seed)}
+		if err := shared.WritePairsHeader(binaryBuffered, header); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err := bufferedWriter.WriteString("{\n  \"pairs\": [\n")
+	if err != nil {
+		return 0, shared.IOError(err)
+	}
+
+	avgDistance, err := shared.GeneratePoints(seed, numPoints, spread, method, bufferedWriter, pairAnswersBuffered, binaryBuffered)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	avgDistance := shared.GeneratePoints(seed, numPoints, spread, bufferedWriter)
+	if binaryBuffered != nil {
+		if err = binaryBuffered.Flush(); err != nil {
+			return 0, shared.IOError(err)
+		}
+	}
 
 	_, err = bufferedWriter.WriteString("   ]\n}")
 	if err != nil {
-		log.Fatal(err)
+		return 0, shared.IOError(err)
+	}
+	err = bufferedWriter.Flush()
+	if err != nil {
+		return 0, shared.IOError(err)
 	}
 
-	err = binary.Write(binFile, binary.LittleEndian, avgDistance)
+	err = binary.Write(pairAnswersBuffered, binary.LittleEndian, avgDistance)
 	if err != nil {
-		log.Fatal(err)
+		return 0, shared.IOError(err)
+	}
+	err = pairAnswersBuffered.Flush()
+	if err != nil {
+		return 0, shared.IOError(err)
 	}
 
-	// Now we Write `avgDistance` to the  answer file
-	// Create a `answer.f64` File for New Validation
-	answerFile, err := os.Create("answer.f64")
+	answer := shared.Answer{
+		SumMethod: method,
+		Average:   avgDistance,
+		PairCount: uint64(numPoints),
+		Seed:      int64(seed),
+		Spread:    spread,
+		JSONHash:  dataHash.Sum64(),
+	}
+	if binaryWriter != nil {
+		answer.BinaryHash = binaryHash.Sum64()
+	}
+	err = shared.WriteAnswer(answerWriter, answer)
 	if err != nil {
-		log.Fatalf("Error creating answer.f64: %v", err)
+		return 0, err
 	}
-	defer answerFile.Close()
 
-	// Write the average distance to the answer file with 16 decimals of precision.
-	err = binary.Write(answerFile, binary.LittleEndian, avgDistance)
-	//_, err = fmt.Fprintf(answerFile, "%.16f\n", avgDistance) //this is a STRING VERSION
+	return avgDistance, nil
+}
+
+func GenerateDataSetAndAnswerFiles(paths OutputPaths, spread string, seed int, numPoints int, method shared.SumMethod) (avgDistance float64, err error) {
+	// Create files
+	outputFile, err := os.Create(paths.Data)
+	if err != nil {
+		return 0, shared.IOError(err)
+	}
+	defer closeFile(outputFile, &err)
 
+	answerFile, err := os.Create(paths.Answer)
 	if err != nil {
-		log.Fatal(err)
+		return 0, shared.IOError(err)
 	}
+	defer closeFile(answerFile, &err)
 
-	return avgDistance
+	pairAnswersFile, err := os.Create(paths.PairAnswers)
+	if err != nil {
+		return 0, shared.IOError(err)
+	}
+	defer closeFile(pairAnswersFile, &err)
+
+	// A nil *os.File in an io.Writer isn't a nil interface, so keep it untyped
+	var binaryWriter io.Writer
+	if paths.Binary != "" {
+		binaryFile, createErr := os.Create(paths.Binary)
+		if createErr != nil {
+			return 0, shared.IOError(createErr)
+		}
+		defer closeFile(binaryFile, &err)
+		binaryWriter = binaryFile
+	}
+
+	return GenerateDataSet(spread, seed, numPoints, method, outputFile, answerFile, pairAnswersFile, binaryWriter)
+}
+
+// closeFile closes a file that was written to and, unless something already
+// failed, reports its close error through err, since a failed close can mean
+// the data never reached the disk.
+func closeFile(file *os.File, err *error) {
+	if closeErr := file.Close(); closeErr != nil && *err == nil {
+		*err = shared.IOError(closeErr)
+	}
 }
@@ -0,0 +1,205 @@
+// Package reptest runs a piece of code over and over until it stops finding a
+// faster run, so the reported minimum is close to the best the machine can do.
+//
+// Usage:
+//
+//	tester.NewTestWave(byteCount, cpuFreq, 10)
+//	for tester.IsTesting() {
+//		tester.BeginTime()
+//		... work ...
+//		tester.EndTime()
+//		tester.CountBytes(byteCount)
+//	}
+package reptest
+
+import (
+	"fmt"
+
+	"github.com/ryank157/perfAware/internal/timing"
+)
+
+type testMode int
+
+const (
+	modeUninitialized testMode = iota
+	modeTesting
+	modeCompleted
+	modeError
+)
+
+// Measurement is what one repetition (or a total over repetitions) cost.
+type Measurement struct {
+	TSC        uint64
+	Bytes      uint64
+	PageFaults uint64
+}
+
+// Results summarize every repetition of one test wave.
+type Results struct {
+	TestCount uint64
+	Total     Measurement
+	Min       Measurement
+	Max       Measurement
+}
+
+// Tester tracks a test wave. The zero value is ready for NewTestWave.
+type Tester struct {
+	TargetProcessedByteCount uint64
+	CPUTimerFreq             uint64
+	TryForTime               uint64 // TSC ticks without a new minimum before the wave ends
+	TestsStartedAt           uint64
+	PrintNewMinimums         bool
+
+	mode            testMode
+	openBlockCount  uint32
+	closeBlockCount uint32
+	current         Measurement
+	Results         Results
+	Err             error
+}
+
+// NewTestWave starts a wave that ends once secondsToTry pass without a new
+// minimum. Calling it again keeps the previous results, so a test can be run
+// in several waves and only report improvements.
+func (t *Tester) NewTestWave(targetProcessedByteCount uint64, cpuTimerFreq uint64, secondsToTry uint32) {
+	if t.mode == modeUninitialized {
+		t.mode = modeTesting
+		t.TargetProcessedByteCount = targetProcessedByteCount
+		t.CPUTimerFreq = cpuTimerFreq
+		t.PrintNewMinimums = true
+		t.Results.Min.TSC = ^uint64(0)
+	} else if t.mode == modeCompleted {
+		t.mode = modeTesting
+
+		if t.TargetProcessedByteCount != targetProcessedByteCount {
+			t.Error("TargetProcessedByteCount changed")
+		}
+		if t.CPUTimerFreq != cpuTimerFreq {
+			t.Error("CPU frequency changed")
+		}
+	}
+
+	t.TryForTime = uint64(secondsToTry) * cpuTimerFreq
+	t.TestsStartedAt = timing.CpuTimer()
+}
+
+// BeginTime starts (or resumes) timing the current repetition.
+func (t *Tester) BeginTime() {
+	t.openBlockCount++
+	t.current.PageFaults -= timing.ReadOSPageFaultCount()
+	t.current.TSC -= timing.CpuTimer()
+}
+
+// EndTime pauses timing the current repetition.
+func (t *Tester) EndTime() {
+	t.current.TSC += timing.CpuTimer()
+	t.current.PageFaults += timing.ReadOSPageFaultCount()
+	t.closeBlockCount++
+}
+
+// CountBytes credits processed bytes to the current repetition.
+func (t *Tester) CountBytes(byteCount uint64) {
+	t.current.Bytes += byteCount
+}
+
+// Error stops the wave; IsTesting returns false from then on.
+func (t *Tester) Error(message string) {
+	t.mode = modeError
+	t.Err = fmt.Errorf("reptest: %s", message)
+	fmt.Printf("ERROR: %s\n", message)
+}
+
+// IsTesting records the repetition that just finished and reports whether
+// another one should be run.
+func (t *Tester) IsTesting() bool {
+	if t.mode != modeTesting {
+		return false
+	}
+
+	currentTime := timing.CpuTimer()
+
+	// Nothing was timed yet on the first call of a wave
+	if t.openBlockCount > 0 {
+		if t.openBlockCount != t.closeBlockCount {
+			t.Error("Unbalanced BeginTime/EndTime")
+		}
+		if t.current.Bytes != t.TargetProcessedByteCount {
+			t.Error(fmt.Sprintf("Processed byte count mismatch: expected %d, got %d", t.TargetProcessedByteCount, t.current.Bytes))
+		}
+
+		if t.mode == modeTesting {
+			results := &t.Results
+			elapsed := t.current
+			results.TestCount++
+			results.Total.TSC += elapsed.TSC
+			results.Total.Bytes += elapsed.Bytes
+			results.Total.PageFaults += elapsed.PageFaults
+
+			if results.Max.TSC < elapsed.TSC {
+				results.Max = elapsed
+			}
+
+			if results.Min.TSC > elapsed.TSC {
+				results.Min = elapsed
+
+				// Any new minimum restarts the clock on the wave
+				t.TestsStartedAt = currentTime
+
+				if t.PrintNewMinimums {
+					PrintMeasurement("Min", elapsed, t.CPUTimerFreq, 1)
+					fmt.Printf("               \r")
+				}
+			}
+
+			t.openBlockCount = 0
+			t.closeBlockCount = 0
+			t.current = Measurement{}
+		}
+	}
+
+	if t.mode == modeTesting && currentTime-t.TestsStartedAt > t.TryForTime {
+		t.mode = modeCompleted
+
+		fmt.Printf("                                                          \r")
+		PrintResults(t.Results, t.CPUTimerFreq)
+	}
+
+	return t.mode == modeTesting
+}
+
+// PrintMeasurement prints a measurement averaged over divisor runs.
+func PrintMeasurement(label string, m Measurement, cpuTimerFreq uint64, divisor uint64) {
+	if divisor == 0 {
+		divisor = 1
+	}
+	tsc := float64(m.TSC) / float64(divisor)
+	bytes := float64(m.Bytes) / float64(divisor)
+	pageFaults := float64(m.PageFaults) / float64(divisor)
+
+	fmt.Printf("%s: %.0f", label, tsc)
+	if cpuTimerFreq > 0 {
+		seconds := tsc / float64(cpuTimerFreq)
+		fmt.Printf(" (%fms)", 1000.0*seconds)
+
+		if bytes > 0 && seconds > 0 {
+			const gigabyte = 1024.0 * 1024.0 * 1024.0
+			fmt.Printf(" %fgb/s", bytes/(gigabyte*seconds))
+		}
+	}
+
+	if pageFaults > 0 {
+		fmt.Printf(" PF: %0.4f (%0.4fk/fault)", pageFaults, bytes/(pageFaults*1024.0))
+	}
+}
+
+// PrintResults prints the min, max and average of a finished wave.
+func PrintResults(results Results, cpuTimerFreq uint64) {
+	PrintMeasurement("Min", results.Min, cpuTimerFreq, 1)
+	fmt.Printf("\n")
+
+	PrintMeasurement("Max", results.Max, cpuTimerFreq, 1)
+	fmt.Printf("\n")
+
+	PrintMeasurement("Avg", results.Total, cpuTimerFreq, results.TestCount)
+	fmt.Printf("\n")
+}
@@ -0,0 +1,129 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+
+	"github.com/ryank157/perfAware/internal/timing"
+)
+
+// AnswerMagic starts every versioned answer file. A file without it is the
+// legacy format: just the average as a little-endian float64.
+//
+// Version 2 layout, all little-endian:
+//
+//	magic [4]byte, version uint32, method uint8, reserved [3]byte,
+//	spread uint32, average float64, pair count uint64, seed int64,
+//	JSON hash uint64, binary hash uint64 (0 when no binary file was written)
+//
+// Version 1 stops after the average.
+var AnswerMagic = [4]byte{'H', 'V', 'A', 'N'}
+
+const (
+	AnswerVersion    = 2
+	answerFileSize   = 56
+	answerFileSizeV1 = 24 // magic, version, method + padding, average
+	legacyAnswerSize = 8
+	answerVersionV1  = 1 // last version without the data set description
+)
+
+// Answer is the content of an answer file.
+type Answer struct {
+	Version   uint32    // 0 when read from a legacy file
+	SumMethod SumMethod // how Average was summed; not recorded in legacy files
+	Average   float64
+
+	// The data set the answer belongs to, from version 2 on
+	PairCount  uint64
+	Seed       int64
+	Spread     string
+	JSONHash   uint64 // HashInput of the JSON file
+	BinaryHash uint64 // HashInput of the binary pairs file, 0 if there is none
+}
+
+// IsLegacy reports whether the answer came from a bare 8-byte file.
+func (a Answer) IsLegacy() bool {
+	return a.Version == 0
+}
+
+// DescribesDataSet reports whether the pair count, seed, spread and hashes are set.
+func (a Answer) DescribesDataSet() bool {
+	return a.Version > answerVersionV1
+}
+
+// HashInput is the hash recorded in the answer file for an input file: 64-bit FNV-1a.
+func HashInput(data []byte) uint64 {
+	defer timing.TimeBandwidth("shared.HashInput", uint64(len(data)))()
+
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// WriteAnswer writes a current version answer file.
+func WriteAnswer(w io.Writer, answer Answer) error {
+	var data [answerFileSize]byte
+	copy(data[0:4], AnswerMagic[:])
+	binary.LittleEndian.PutUint32(data[4:8], AnswerVersion)
+	data[8] = byte(answer.SumMethod)
+	binary.LittleEndian.PutUint32(data[12:16], spreadCode(answer.Spread))
+	binary.LittleEndian.PutUint64(data[16:24], math.Float64bits(answer.Average))
+	binary.LittleEndian.PutUint64(data[24:32], answer.PairCount)
+	binary.LittleEndian.PutUint64(data[32:40], uint64(answer.Seed))
+	binary.LittleEndian.PutUint64(data[40:48], answer.JSONHash)
+	binary.LittleEndian.PutUint64(data[48:56], answer.BinaryHash)
+
+	if _, err := w.Write(data[:]); err != nil {
+		return IOError(err)
+	}
+	return nil
+}
+
+// ParseAnswer decodes an answer file of any version, including legacy.
+func ParseAnswer(data []byte) (Answer, error) {
+	if len(data) == legacyAnswerSize {
+		return Answer{Average: math.Float64frombits(binary.LittleEndian.Uint64(data))}, nil
+	}
+
+	if len(data) < 8 || !bytes.Equal(data[0:4], AnswerMagic[:]) {
+		return Answer{}, fmt.Errorf("%w: expected a %d byte legacy answer or a file starting with %q, got %d bytes",
+			ErrAnswerSize, legacyAnswerSize, AnswerMagic[:], len(data))
+	}
+
+	answer := Answer{Version: binary.LittleEndian.Uint32(data[4:8])}
+	expectedSize := 0
+	switch answer.Version {
+	case answerVersionV1:
+		expectedSize = answerFileSizeV1
+	case AnswerVersion:
+		expectedSize = answerFileSize
+	default:
+		return Answer{}, fmt.Errorf("%w: unsupported answer file version %d", ErrAnswerMalformed, answer.Version)
+	}
+	if len(data) != expectedSize {
+		return Answer{}, fmt.Errorf("%w: expected %d bytes for version %d, got %d", ErrAnswerSize, expectedSize, answer.Version, len(data))
+	}
+
+	answer.SumMethod = SumMethod(data[8])
+	if !answer.SumMethod.IsValid() {
+		return Answer{}, fmt.Errorf("%w: unknown summation method %d", ErrAnswerMalformed, data[8])
+	}
+	answer.Average = math.Float64frombits(binary.LittleEndian.Uint64(data[16:24]))
+
+	if answer.DescribesDataSet() {
+		spread, ok := spreadName(binary.LittleEndian.Uint32(data[12:16]))
+		if !ok {
+			return Answer{}, fmt.Errorf("%w: unknown spread code %d", ErrAnswerMalformed, binary.LittleEndian.Uint32(data[12:16]))
+		}
+		answer.Spread = spread
+		answer.PairCount = binary.LittleEndian.Uint64(data[24:32])
+		answer.Seed = int64(binary.LittleEndian.Uint64(data[32:40]))
+		answer.JSONHash = binary.LittleEndian.Uint64(data[40:48])
+		answer.BinaryHash = binary.LittleEndian.Uint64(data[48:56])
+	}
+	return answer, nil
+}
@@ -0,0 +1,71 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAnswerFileVersions(t *testing.T) {
+	want := Answer{
+		Version:    AnswerVersion,
+		SumMethod:  SumKahan,
+		Average:    1234.5678,
+		PairCount:  1000,
+		Seed:       -42,
+		Spread:     cluster,
+		JSONHash:   HashInput([]byte("json")),
+		BinaryHash: HashInput([]byte("binary")),
+	}
+	var buf bytes.Buffer
+	if err := WriteAnswer(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseAnswer(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("round trip: got %+v, want %+v", got, want)
+	}
+
+	// Version 1 is the first 24 bytes with the version patched
+	v1 := bytes.Clone(buf.Bytes()[:answerFileSizeV1])
+	binary.LittleEndian.PutUint32(v1[4:8], answerVersionV1)
+	got, err = ParseAnswer(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DescribesDataSet() || got.SumMethod != SumKahan || got.Average != want.Average {
+		t.Errorf("version 1: got %+v", got)
+	}
+
+	legacy := binary.LittleEndian.AppendUint64(nil, math.Float64bits(want.Average))
+	got, err = ParseAnswer(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsLegacy() || got.SumMethod != SumNaive || got.Average != want.Average {
+		t.Errorf("legacy: got %+v", got)
+	}
+
+	if _, err := ParseAnswer(buf.Bytes()[:answerFileSize-1]); !errors.Is(err, ErrAnswerSize) {
+		t.Errorf("truncated: got %v, want ErrAnswerSize", err)
+	}
+
+	malformed := map[string]func(b []byte){
+		"version":    func(b []byte) { binary.LittleEndian.PutUint32(b[4:8], AnswerVersion+1) },
+		"sum method": func(b []byte) { b[8] = 0xff },
+		"spread":     func(b []byte) { binary.LittleEndian.PutUint32(b[12:16], 0xffff) },
+	}
+	for name, corrupt := range malformed {
+		b := bytes.Clone(buf.Bytes())
+		corrupt(b)
+		_, err := ParseAnswer(b)
+		if !errors.Is(err, ErrAnswerMalformed) || ExitCode(err) != ExitAnswerMalformed {
+			t.Errorf("%s: got %v (exit %d), want ErrAnswerMalformed", name, err, ExitCode(err))
+		}
+	}
+}
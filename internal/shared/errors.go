@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error classes returned by the library packages. Wrap one of these so callers
+// can tell failures apart with errors.Is; only the cmd/ mains decide to exit.
+var (
+	ErrInvalidSpread   = errors.New("invalid spread type")
+	ErrMalformedInput  = errors.New("malformed input")
+	ErrAnswerSize      = errors.New("answer file size mismatch")
+	ErrIO              = errors.New("i/o failure")
+	ErrDataSetMismatch = errors.New("answer file belongs to a different data set")
+	ErrAnswerMalformed = errors.New("malformed answer file")
+)
+
+// Exit codes used by the cmd/ mains, one per error class.
+const (
+	ExitOK              = 0
+	ExitFailure         = 1 // anything not classified below
+	ExitUsage           = 2
+	ExitIO              = 3
+	ExitMalformedInput  = 4
+	ExitAnswerSize      = 5
+	ExitInvalidSpread   = 6
+	ExitValidation      = 7 // ran fine, but the result is outside tolerance
+	ExitDataSetMismatch = 8
+	ExitAnswerMalformed = 9
+)
+
+// ExitCode maps an error returned by the library packages to a process exit code.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrInvalidSpread):
+		return ExitInvalidSpread
+	case errors.Is(err, ErrMalformedInput):
+		return ExitMalformedInput
+	case errors.Is(err, ErrAnswerSize):
+		return ExitAnswerSize
+	case errors.Is(err, ErrDataSetMismatch):
+		return ExitDataSetMismatch
+	case errors.Is(err, ErrAnswerMalformed):
+		return ExitAnswerMalformed
+	case errors.Is(err, ErrIO):
+		return ExitIO
+	default:
+		return ExitFailure
+	}
+}
+
+// CheckSpread returns ErrInvalidSpread unless spreadType is "uniform" or "cluster".
+func CheckSpread(spreadType string) error {
+	if spreadType != uniform && spreadType != cluster {
+		return fmt.Errorf("%w: %q, must be '%s' or '%s'", ErrInvalidSpread, spreadType, uniform, cluster)
+	}
+	return nil
+}
+
+// IOError wraps an I/O failure so it is classified as ErrIO.
+func IOError(err error) error {
+	return fmt.Errorf("%w: %w", ErrIO, err)
+}
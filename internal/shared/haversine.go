@@ -2,10 +2,13 @@ package shared
 
 import (
 	"bufio"
+	"encoding/binary"
 	"fmt"
-	"log"
 	"math"
 	"math/rand"
+	"strconv"
+	"sync"
+	"unsafe"
 
 	"github.com/ryank157/perfAware/internal/timing"
 )
@@ -35,10 +38,20 @@ type HaversineData struct {
 	pairs []HaversinePair
 }
 
-func GeneratePoints(seed int, numPoints int, spreadType string, writer *bufio.Writer) float64 {
+// jsonCoordDecimals is how many decimals GeneratePoints writes per coordinate.
+const jsonCoordDecimals = 15
+
+var coordPrefixes = [4]string{`{"X0":`, `,"Y0":`, `,"X1":`, `,"Y1":`}
+
+// GeneratePoints writes numPoints random pairs as JSON to writer, packed to
+// binaryWriter when it isn't nil, and the distance of every pair, in order, as
+// little-endian float64s to answerWriter. Distances are computed from the
+// coordinates as the JSON rounds them. It returns the average distance
+// summed with method.
+func GeneratePoints(seed int, numPoints int, spreadType string, method SumMethod, writer *bufio.Writer, answerWriter *bufio.Writer, binaryWriter *bufio.Writer) (float64, error) {
 	defer timing.TimeFunction()()
 	r := rand.New(rand.NewSource(int64(seed)))
-	sum := 0.0
+	summer := NewSummer(method, numPoints)
 	isFirst := true
 
 	var clusters []Cluster
@@ -66,12 +79,13 @@ func GeneratePoints(seed int, numPoints int, spreadType string, writer *bufio.Wr
 			}
 		}
 	} else {
-		log.Fatalf("Invalid spread type: %s", spreadType)
-		return 0 // Unreachable
+		return 0, CheckSpread(spreadType)
 	}
 
 	clusterIndex := 0
 	pointsInCluster := 0
+	var distBytes [8]byte
+	var pairJSON []byte
 
 	for range numPoints {
 		if pointsInCluster >= ptsPerCluster {
@@ -85,36 +99,54 @@ func GeneratePoints(seed int, numPoints int, spreadType string, writer *bufio.Wr
 		c := clusters[clusterIndex]
 		p0 := Point{r.Float64()*(c.Xmax-c.Xmin) + c.Xmin, r.Float64()*(c.Ymax-c.Ymin) + c.Ymin}
 		p1 := Point{r.Float64()*(c.Xmax-c.Xmin) + c.Xmin, r.Float64()*(c.Ymax-c.Ymin) + c.Ymin}
-		dist := Haversine(HaversinePair{p0.X, p0.Y, p1.X, p1.Y})
-		sum += dist
-
-		pair := HaversinePair{
-			X0: p0.X,
-			Y0: p0.Y,
-			X1: p1.X,
-			Y1: p1.Y,
+
+		// The JSON rounds the coordinates, so the pair is read back from the
+		// text: the distances and the binary file then describe exactly the
+		// data a validator parses
+		coords := [4]float64{p0.X, p0.Y, p1.X, p1.Y}
+		pairJSON = pairJSON[:0]
+		for i, coord := range coords {
+			pairJSON = append(pairJSON, coordPrefixes[i]...)
+			start := len(pairJSON)
+			pairJSON = strconv.AppendFloat(pairJSON, coord, 'f', jsonCoordDecimals, 64)
+			coords[i], _ = strconv.ParseFloat(string(pairJSON[start:]), 64)
 		}
+		pairJSON = append(pairJSON, '}')
+		pair := HaversinePair{X0: coords[0], Y0: coords[1], X1: coords[2], Y1: coords[3]}
 
-		pairJSON := fmt.Sprintf(`{"X0":%.15f,"Y0":%.15f,"X1":%.15f,"Y1":%.15f}`, pair.X0, pair.Y0, pair.X1, pair.Y1)
+		dist := Haversine(pair)
+		summer.Add(dist)
+
+		if binaryWriter != nil {
+			if err := WritePair(binaryWriter, pair); err != nil {
+				return 0, err
+			}
+		}
 
 		if !isFirst {
 			_, err := writer.WriteString(",\n")
 			if err != nil {
-				log.Fatal(err)
+				return 0, IOError(err)
 			}
 		}
 		_, err := writer.WriteString("    ")
 		if err != nil {
-			log.Fatal(err)
+			return 0, IOError(err)
+		}
+		_, err = writer.Write(pairJSON)
+		if err != nil {
+			return 0, IOError(err)
 		}
-		_, err = writer.WriteString(pairJSON)
+
+		binary.LittleEndian.PutUint64(distBytes[:], math.Float64bits(dist))
+		_, err = answerWriter.Write(distBytes[:])
 		if err != nil {
-			log.Fatal(err)
+			return 0, IOError(err)
 		}
 		isFirst = false
 		pointsInCluster++
 	}
-	return sum / float64(numPoints)
+	return summer.Average(), nil
 
 }
 
@@ -141,14 +173,80 @@ func Haversine(pair HaversinePair) float64 {
 	return c
 }
 
-func SumHaversineDistances(pairCount int, pairs []HaversinePair) float64 {
+// haversineAnchor times each distance in SumHaversineDistances, too often for
+// TimeBlock.
+var haversineAnchor = timing.NewAnchor("Haversine")
+
+// SumHaversineDistances returns the average distance of the pairs, summed in
+// pair order with method.
+func SumHaversineDistances(pairCount int, pairs []HaversinePair, method SumMethod) float64 {
 	defer timing.TimeFunction()()
-	sum := 0.0
-	sumCoef := 1.0 / float64(pairCount)
+	summer := NewSummer(method, pairCount)
 	for pairIndex := range pairCount {
 		pair := pairs[pairIndex]
+		block := haversineAnchor.Begin()
 		dist := Haversine(pair)
-		sum += sumCoef * dist
+		block.End()
+		summer.Add(dist)
+	}
+	return summer.Average()
+}
+
+// parallelChunkSize is how many pairs go into each partial sum. Chunk boundaries
+// and the order the partial sums are added don't depend on the worker count, so
+// SumHaversineDistancesParallel is bit-identical for any number of workers.
+const parallelChunkSize = 16 * 1024
+
+// SumHaversineDistancesParallel splits pairs into fixed size chunks spread over
+// workerCount goroutines, then adds the per-chunk sums in chunk order. Each
+// chunk and the final reduction use method, so the result is deterministic
+// but not the same order as SumHaversineDistances.
+func SumHaversineDistancesParallel(pairs []HaversinePair, workerCount int, method SumMethod) float64 {
+	defer timing.TimeFunction()()
+	pairCount := len(pairs)
+	if pairCount == 0 {
+		return 0
+	}
+	workerCount = max(workerCount, 1)
+
+	chunkCount := (pairCount + parallelChunkSize - 1) / parallelChunkSize
+	partials := make([]float64, chunkCount)
+
+	var wg sync.WaitGroup
+	for worker := range min(workerCount, chunkCount) {
+		// Worker w takes chunks w, w+workerCount, w+2*workerCount, ...
+		workerPairs := 0
+		for chunk := worker; chunk < chunkCount; chunk += workerCount {
+			workerPairs += min(parallelChunkSize, pairCount-chunk*parallelChunkSize)
+		}
+		byteCount := uint64(workerPairs) * uint64(unsafe.Sizeof(HaversinePair{}))
+		ctx := timing.NewContext()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer ctx.TimeBandwidth(fmt.Sprintf("Worker %d", worker), byteCount)()
+
+			for chunk := worker; chunk < chunkCount; chunk += workerCount {
+				start := chunk * parallelChunkSize
+				end := min(start+parallelChunkSize, pairCount)
+				summer := NewSummer(method, pairCount)
+				for _, pair := range pairs[start:end] {
+					summer.Add(Haversine(pair))
+				}
+				partials[chunk] = summer.Total()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Partials are already scaled for SumScaled, so combine them unscaled
+	reduction := NewSummer(method, 0)
+	for _, partial := range partials {
+		reduction.Add(partial)
+	}
+	if method == SumScaled {
+		return reduction.Total()
 	}
-	return sum
+	return reduction.Total() / float64(pairCount)
 }
@@ -0,0 +1,139 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+
+	"github.com/ryank157/perfAware/internal/timing"
+)
+
+// PairsMagic starts a binary pairs file. The layout, all little-endian, is
+//
+//	magic [4]byte, version uint32, pair count uint64,
+//	spread uint32, reserved uint32, seed int64,
+//	then pair count * (X0, Y0, X1, Y1 float64)
+var PairsMagic = [4]byte{'H', 'V', 'P', 'R'}
+
+const (
+	PairsVersion      = 1
+	PairsHeaderSize   = 32
+	pairEncodingBytes = 4 * 8
+)
+
+// Spread codes stored in binary pairs and answer files.
+const (
+	spreadCodeUniform uint32 = 0
+	spreadCodeCluster uint32 = 1
+)
+
+// PairsHeader describes a binary pairs file.
+type PairsHeader struct {
+	Version   uint32
+	PairCount uint64
+	Spread    string
+	Seed      int64
+}
+
+// IsPairsFile reports whether data starts like a binary pairs file.
+func IsPairsFile(data []byte) bool {
+	return len(data) >= len(PairsMagic) && bytes.Equal(data[:len(PairsMagic)], PairsMagic[:])
+}
+
+// WritePairsHeader writes the header; the pairs follow with WritePair.
+func WritePairsHeader(w io.Writer, header PairsHeader) error {
+	var data [PairsHeaderSize]byte
+	copy(data[0:4], PairsMagic[:])
+	binary.LittleEndian.PutUint32(data[4:8], PairsVersion)
+	binary.LittleEndian.PutUint64(data[8:16], header.PairCount)
+	binary.LittleEndian.PutUint32(data[16:20], spreadCode(header.Spread))
+	binary.LittleEndian.PutUint64(data[24:32], uint64(header.Seed))
+
+	if _, err := w.Write(data[:]); err != nil {
+		return IOError(err)
+	}
+	return nil
+}
+
+// WritePair appends one packed pair.
+func WritePair(w io.Writer, pair HaversinePair) error {
+	var data [pairEncodingBytes]byte
+	binary.LittleEndian.PutUint64(data[0:8], math.Float64bits(pair.X0))
+	binary.LittleEndian.PutUint64(data[8:16], math.Float64bits(pair.Y0))
+	binary.LittleEndian.PutUint64(data[16:24], math.Float64bits(pair.X1))
+	binary.LittleEndian.PutUint64(data[24:32], math.Float64bits(pair.Y1))
+
+	if _, err := w.Write(data[:]); err != nil {
+		return IOError(err)
+	}
+	return nil
+}
+
+// ReadPairsHeader decodes and checks the header of a binary pairs file,
+// including that data is exactly as long as the header says.
+func ReadPairsHeader(data []byte) (PairsHeader, error) {
+	if len(data) < PairsHeaderSize || !IsPairsFile(data) {
+		return PairsHeader{}, fmt.Errorf("%w: not a binary pairs file", ErrMalformedInput)
+	}
+
+	header := PairsHeader{
+		Version:   binary.LittleEndian.Uint32(data[4:8]),
+		PairCount: binary.LittleEndian.Uint64(data[8:16]),
+		Seed:      int64(binary.LittleEndian.Uint64(data[24:32])),
+	}
+	if header.Version != PairsVersion {
+		return header, fmt.Errorf("%w: unsupported binary pairs version %d", ErrMalformedInput, header.Version)
+	}
+	spread, ok := spreadName(binary.LittleEndian.Uint32(data[16:20]))
+	if !ok {
+		return header, fmt.Errorf("%w: unknown spread code %d", ErrMalformedInput, binary.LittleEndian.Uint32(data[16:20]))
+	}
+	header.Spread = spread
+
+	payload := uint64(len(data) - PairsHeaderSize)
+	if payload%pairEncodingBytes != 0 || payload/pairEncodingBytes != header.PairCount {
+		return header, fmt.Errorf("%w: header says %d pairs but the file holds %d bytes of pair data",
+			ErrMalformedInput, header.PairCount, payload)
+	}
+	return header, nil
+}
+
+// DecodePairs copies the packed pairs after the header into pairs and returns
+// how many were copied. No parsing, just little-endian loads.
+func DecodePairs(data []byte, pairs []HaversinePair) int {
+	payload := data[PairsHeaderSize:]
+	pairCount := min(len(payload)/pairEncodingBytes, len(pairs))
+	defer timing.TimeBandwidth("Decode binary pairs", uint64(pairCount)*uint64(unsafe.Sizeof(HaversinePair{})))()
+
+	for i := range pairCount {
+		p := payload[i*pairEncodingBytes:]
+		pairs[i] = HaversinePair{
+			X0: math.Float64frombits(binary.LittleEndian.Uint64(p[0:8])),
+			Y0: math.Float64frombits(binary.LittleEndian.Uint64(p[8:16])),
+			X1: math.Float64frombits(binary.LittleEndian.Uint64(p[16:24])),
+			Y1: math.Float64frombits(binary.LittleEndian.Uint64(p[24:32])),
+		}
+	}
+	return pairCount
+}
+
+func spreadCode(spread string) uint32 {
+	if spread == cluster {
+		return spreadCodeCluster
+	}
+	return spreadCodeUniform
+}
+
+func spreadName(code uint32) (string, bool) {
+	switch code {
+	case spreadCodeUniform:
+		return uniform, true
+	case spreadCodeCluster:
+		return cluster, true
+	default:
+		return "", false
+	}
+}
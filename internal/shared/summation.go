@@ -0,0 +1,144 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// SumMethod selects how distances are accumulated into the average. The
+// generator records the method in the answer file so the validator can add
+// the distances in exactly the same order.
+type SumMethod uint8
+
+const (
+	SumNaive    SumMethod = iota // sum += d, divided by the count at the end
+	SumScaled                    // sum += d/count, each term scaled before adding
+	SumKahan                     // Kahan compensated summation
+	SumNeumaier                  // Neumaier's improved Kahan, also handles terms larger than the sum
+	SumPairwise                  // pairwise (cascade) summation over a binary tree of partial sums
+	sumMethodCount
+)
+
+var sumMethodNames = [sumMethodCount]string{"naive", "scaled", "kahan", "neumaier", "pairwise"}
+
+func (m SumMethod) String() string {
+	if m < sumMethodCount {
+		return sumMethodNames[m]
+	}
+	return fmt.Sprintf("SumMethod(%d)", uint8(m))
+}
+
+// IsValid reports whether m is one of the known methods.
+func (m SumMethod) IsValid() bool {
+	return m < sumMethodCount
+}
+
+// SumMethodNames lists the method names for usage messages.
+func SumMethodNames() string {
+	return strings.Join(sumMethodNames[:], ", ")
+}
+
+// ParseSumMethod looks a method up by name.
+func ParseSumMethod(name string) (SumMethod, error) {
+	for i, methodName := range sumMethodNames {
+		if methodName == name {
+			return SumMethod(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown summation method %q, must be one of %s", name, SumMethodNames())
+}
+
+// Summer adds values one at a time with a SumMethod. Every method streams, so
+// the generator and validator can both feed it in pair order without keeping
+// all the distances around.
+type Summer struct {
+	method SumMethod
+	count  int
+	coef   float64 // SumScaled only
+
+	sum          float64
+	compensation float64 // SumKahan, SumNeumaier
+
+	// SumPairwise: levels[i] holds the sum of a full block of 2^i values while
+	// bit i of levelMask is set, like the digits of a binary counter.
+	levels    [64]float64
+	levelMask uint64
+}
+
+// NewSummer returns a Summer for expectedCount values. SumScaled multiplies
+// each value by 1/expectedCount; pass 0 to add values unscaled, e.g. when
+// combining partial sums that were already scaled.
+func NewSummer(method SumMethod, expectedCount int) Summer {
+	coef := 1.0
+	if expectedCount > 0 {
+		coef = 1.0 / float64(expectedCount)
+	}
+	return Summer{method: method, coef: coef}
+}
+
+// Add accumulates x.
+func (s *Summer) Add(x float64) {
+	s.count++
+	switch s.method {
+	case SumScaled:
+		s.sum += s.coef * x
+	case SumKahan:
+		y := x - s.compensation
+		t := s.sum + y
+		s.compensation = (t - s.sum) - y
+		s.sum = t
+	case SumNeumaier:
+		t := s.sum + x
+		if math.Abs(s.sum) >= math.Abs(x) {
+			s.compensation += (s.sum - t) + x
+		} else {
+			s.compensation += (x - t) + s.sum
+		}
+		s.sum = t
+	case SumPairwise:
+		carry := x
+		level := 0
+		for s.levelMask&(1<<level) != 0 {
+			carry = s.levels[level] + carry
+			s.levelMask &^= 1 << level
+			level++
+		}
+		s.levels[level] = carry
+		s.levelMask |= 1 << level
+	default:
+		s.sum += x
+	}
+}
+
+// Total is the accumulated sum. For SumScaled it is already divided by the
+// expected count.
+func (s *Summer) Total() float64 {
+	switch s.method {
+	case SumNeumaier:
+		return s.sum + s.compensation
+	case SumPairwise:
+		total := 0.0
+		for level := range s.levels {
+			if s.levelMask&(1<<level) != 0 {
+				total = s.levels[level] + total
+			}
+		}
+		return total
+	default:
+		return s.sum
+	}
+}
+
+// Average is Total divided by the number of values added.
+func (s *Summer) Average() float64 {
+	if s.method == SumScaled {
+		return s.Total()
+	}
+	return s.Total() / float64(s.count)
+}
+
+// Count is the number of values added so far.
+func (s *Summer) Count() int {
+	return s.count
+}
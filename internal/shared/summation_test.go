@@ -0,0 +1,56 @@
+package shared
+
+import (
+	"math"
+	"testing"
+)
+
+func pairwiseReference(values []float64) float64 {
+	if len(values) == 1 {
+		return values[0]
+	}
+	half := len(values) / 2
+	return pairwiseReference(values[:half]) + pairwiseReference(values[half:])
+}
+
+func TestSummerMethods(t *testing.T) {
+	// 1 followed by many values too small to register in a naive sum
+	values := make([]float64, 1024)
+	values[0] = 1
+	for i := 1; i < len(values); i++ {
+		values[i] = 1e-16
+	}
+	exact := 1 + 1023e-16
+
+	sum := func(method SumMethod) float64 {
+		summer := NewSummer(method, len(values))
+		for _, v := range values {
+			summer.Add(v)
+		}
+		return summer.Average() * float64(len(values))
+	}
+
+	if got := sum(SumNaive); got != 1 {
+		t.Errorf("naive: expected the small terms to be lost, got %.17g", got)
+	}
+	for _, method := range []SumMethod{SumKahan, SumNeumaier} {
+		if got := sum(method); math.Abs(got-exact) > 1e-16 {
+			t.Errorf("%s: got %.17g, want %.17g", method, got, exact)
+		}
+	}
+
+	summer := NewSummer(SumPairwise, 0)
+	for _, v := range values {
+		summer.Add(v)
+	}
+	if got, want := summer.Total(), pairwiseReference(values); got != want {
+		t.Errorf("pairwise: got %.17g, want %.17g", got, want)
+	}
+
+	for i := range sumMethodCount {
+		method, err := ParseSumMethod(i.String())
+		if err != nil || method != i {
+			t.Errorf("ParseSumMethod(%q) = %v, %v", i.String(), method, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/ryank157/perfAware/internal/timing"
@@ -25,10 +26,29 @@ func FreeBuffer(buf *Buffer) {
 
 // struct and func from earlier replies
 func ReadEntireFile(fileName string) (Buffer, error) {
-	defer timing.TimeFunction()()
-	data, err := os.ReadFile(fileName)
+	file, err := os.Open(fileName)
 	if err != nil {
-		return Buffer{}, fmt.Errorf("unable to read file: %w", err) // Wrap error for context
+		return Buffer{}, fmt.Errorf("%w: unable to read file: %w", ErrIO, err) // Wrap error for context
+	}
+	defer file.Close()
+
+	// The size of the open file tells the profile block how many bytes it reads
+	info, err := file.Stat()
+	if err != nil {
+		return Buffer{}, fmt.Errorf("%w: unable to read file: %w", ErrIO, err)
+	}
+	fileSize := info.Size()
+	defer timing.TimeBandwidth("shared.ReadEntireFile", uint64(fileSize))()
+
+	var data []byte
+	if fileSize > 0 {
+		data = make([]byte, fileSize)
+		_, err = io.ReadFull(file, data)
+	} else {
+		data, err = io.ReadAll(file) // pipes and special files report no size
+	}
+	if err != nil {
+		return Buffer{}, fmt.Errorf("%w: unable to read file: %w", ErrIO, err)
 	}
 	return Buffer{Data: data, Count: int64(len(data))}, nil
 }
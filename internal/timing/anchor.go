@@ -0,0 +1,98 @@
+//go:build !timing
+
+package timing
+
+import "sync/atomic"
+
+// Anchor is a block label registered once, so timing it costs no map lookup
+// and no allocation. Declare it at package level and time hot code with
+//
+//	var haversineAnchor = timing.NewAnchor("Haversine")
+//
+//	block := haversineAnchor.Begin()
+//	...
+//	block.End()
+//
+// The same label still gets a node per parent in the call tree, like
+// TimeBlock; the anchor caches the node index for the parents it was last
+// opened under in the current profiler session.
+//
+// Anchor blocks don't read the OS metrics or perf counters the profiler may
+// capture, since those cost a syscall per block; see CaptureCounters.
+type Anchor struct {
+	label    string
+	labelID  int32
+	counters bool
+	cache    [anchorCacheSize]atomic.Uint64 // epoch << 32 | parent+1 << 16 | anchor index, by parent % anchorCacheSize
+}
+
+const anchorCacheSize = 8
+
+// NewAnchor registers label for Begin and End.
+func NewAnchor(label string) *Anchor {
+	return &Anchor{label: label, labelID: internLabel(label)}
+}
+
+// CaptureCounters makes the anchor's blocks read the OS metrics and perf
+// counters like TimeBlock does, when the profiler captures them. Call it at
+// declaration, before any block is open:
+//
+//	var parseAnchor = timing.NewAnchor("Parse").CaptureCounters()
+func (a *Anchor) CaptureCounters() *Anchor {
+	a.counters = true
+	return a
+}
+
+// Label is the name the anchor's blocks get in the profile.
+func (a *Anchor) Label() string {
+	return a.label
+}
+
+// Block is an open block of an Anchor. It is a plain value, End it exactly once.
+type Block struct {
+	context *Context // nil when timing is disabled
+	open    openBlock
+}
+
+// Begin opens a block of the anchor in the default context.
+func (a *Anchor) Begin() Block {
+	return a.BeginBandwidth(0)
+}
+
+// BeginBandwidth is Begin with byteCount processed bytes credited to the block.
+func (a *Anchor) BeginBandwidth(byteCount uint64) Block {
+	if !IsTimingEnabled() {
+		return Block{}
+	}
+	return a.BeginIn(&ActiveProfiler().defaultContext, byteCount)
+}
+
+// BeginIn opens a block of the anchor in context c, for goroutines that have
+// their own Context.
+func (a *Anchor) BeginIn(c *Context, byteCount uint64) Block {
+	if !IsTimingEnabled() {
+		return Block{}
+	}
+	return Block{context: c, open: c.openAnchor(a.label, byteCount, a)}
+}
+
+// End closes the block.
+func (b Block) End() {
+	if b.context != nil {
+		b.context.close(b.open)
+	}
+}
+
+// indexUnder returns the profiler anchor for this label under parent, going
+// to the profiler's map only when the cache slot holds another parent.
+func (a *Anchor) indexUnder(p *Profiler, parent int32) int32 {
+	// Anchor indices and parents are below len(Profiler.Anchors), 16 bits each
+	key := uint64(p.epoch.Load())<<32 | uint64(parent+1)<<16
+	slot := &a.cache[uint32(parent)%anchorCacheSize]
+	if cached := slot.Load(); cached&^0xFFFF == key {
+		return int32(cached & 0xFFFF)
+	}
+	index := p.getOrAddAnchor(parent, a.label)
+	slot.Store(key | uint64(index))
+	return index
+}
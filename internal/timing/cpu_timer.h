@@ -1,8 +0,0 @@
-#ifndef CPU_TIMER_H
-#define CPU_TIMER_H
-
-#include <stdint.h>
-
-uint64_t cpu_timer();
-
-#endif // CPU_TIMER_H
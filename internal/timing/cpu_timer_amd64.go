@@ -0,0 +1,11 @@
+package timing
+
+const cpuTimerSource = "rdtsc"
+
+// CpuTimer reads the CPU timestamp counter (rdtsc).
+func CpuTimer() uint64
+
+// cpuTimerFreq is 0 because the TSC rate has to be estimated against the OS timer.
+func cpuTimerFreq() uint64 {
+	return 0
+}
@@ -0,0 +1,9 @@
+#include "textflag.h"
+
+// func CpuTimer() uint64
+TEXT ·CpuTimer(SB), NOSPLIT, $0-8
+	RDTSC
+	SHLQ $32, DX
+	ORQ  DX, AX
+	MOVQ AX, ret+0(FP)
+	RET
@@ -0,0 +1,9 @@
+package timing
+
+const cpuTimerSource = "cntvct_el0"
+
+// CpuTimer reads the virtual counter (cntvct_el0).
+func CpuTimer() uint64
+
+// cpuTimerFreq reads the counter frequency from cntfrq_el0.
+func cpuTimerFreq() uint64
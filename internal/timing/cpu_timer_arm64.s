@@ -0,0 +1,14 @@
+#include "textflag.h"
+
+// func CpuTimer() uint64
+TEXT ·CpuTimer(SB), NOSPLIT, $0-8
+	ISB  $15
+	MRS  CNTVCT_EL0, R0
+	MOVD R0, ret+0(FP)
+	RET
+
+// func cpuTimerFreq() uint64
+TEXT ·cpuTimerFreq(SB), NOSPLIT, $0-8
+	MRS  CNTFRQ_EL0, R0
+	MOVD R0, ret+0(FP)
+	RET
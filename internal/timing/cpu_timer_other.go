@@ -0,0 +1,17 @@
+//go:build !amd64 && !arm64
+
+package timing
+
+import "time"
+
+const cpuTimerSource = "os"
+
+// CpuTimer falls back to the OS clock in nanoseconds on architectures without
+// a counter we know how to read.
+func CpuTimer() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+func cpuTimerFreq() uint64 {
+	return 1_000_000_000
+}
@@ -0,0 +1,452 @@
+//go:build !timing
+
+package timing
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile output formats accepted by WriteProfile.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// ProfileEntry is the measured state of one node of the call tree.
+type ProfileEntry struct {
+	Label               string     `json:"label"`
+	Path                string     `json:"path"`  // labels from the root down, joined with "/"
+	Depth               int        `json:"depth"` // 1 for blocks opened directly under the root
+	HitCount            uint64     `json:"hitCount"`
+	CounterHitCount     uint64     `json:"counterHitCount,omitempty"` // hits that also read the OS metrics or perf counters
+	TSCExclusive        uint64     `json:"tscExclusive"`
+	TSCInclusive        uint64     `json:"tscInclusive"`
+	ExclusiveMs         float64    `json:"exclusiveMs"`
+	InclusiveMs         float64    `json:"inclusiveMs"`
+	Percent             float64    `json:"percent"`
+	PercentWithChildren float64    `json:"percentWithChildren"`
+	ProcessedBytes      uint64     `json:"processedBytes,omitempty"`
+	Megabytes           float64    `json:"megabytes,omitempty"`
+	GigabytesPerSecond  float64    `json:"gigabytesPerSecond,omitempty"` // over the inclusive time
+	Clamped             bool       `json:"clamped,omitempty"`            // exclusive exceeded the total and was clamped to it
+	OS                  *OSEntry   `json:"os,omitempty"`                 // inclusive, only when the profiler captured OS metrics
+	Perf                *PerfEntry `json:"perf,omitempty"`               // inclusive, only when the profiler had perf counters
+	OverheadTSC         uint64     `json:"overheadTsc,omitempty"`        // estimated profiler overhead in TSCExclusive
+}
+
+// OSEntry is an OSMetrics delta as exported in a profile.
+type OSEntry struct {
+	MinorFaults         uint64  `json:"minorFaults"`
+	MajorFaults         uint64  `json:"majorFaults"`
+	VoluntarySwitches   uint64  `json:"voluntarySwitches"`
+	InvoluntarySwitches uint64  `json:"involuntarySwitches"`
+	UserMs              float64 `json:"userMs"`
+	SystemMs            float64 `json:"systemMs"`
+}
+
+func newOSEntry(metrics OSMetrics) *OSEntry {
+	return &OSEntry{
+		MinorFaults:         metrics.MinorFaults,
+		MajorFaults:         metrics.MajorFaults,
+		VoluntarySwitches:   metrics.VoluntarySwitches,
+		InvoluntarySwitches: metrics.InvoluntarySwitches,
+		UserMs:              float64(metrics.UserTime) / float64(time.Millisecond),
+		SystemMs:            float64(metrics.SystemTime) / float64(time.Millisecond),
+	}
+}
+
+// PerfEntry is a PerfCounts delta as exported in a profile. Events missing
+// from ProfileSnapshot.PerfEvents read zero, and so do the ratios built on them.
+type PerfEntry struct {
+	Instructions uint64  `json:"instructions"`
+	Cycles       uint64  `json:"cycles"`
+	BranchMisses uint64  `json:"branchMisses"`
+	CacheMisses  uint64  `json:"cacheMisses"`
+	TaskClockMs  float64 `json:"taskClockMs"`
+	PageFaults   uint64  `json:"pageFaults"`
+	IPC          float64 `json:"ipc"`        // instructions per cycle
+	BranchMPKI   float64 `json:"branchMpki"` // branch misses per thousand instructions
+	CacheMPKI    float64 `json:"cacheMpki"`  // cache misses per thousand instructions
+}
+
+func newPerfEntry(counts PerfCounts) *PerfEntry {
+	entry := &PerfEntry{
+		Instructions: counts[PerfInstructions],
+		Cycles:       counts[PerfCycles],
+		BranchMisses: counts[PerfBranchMisses],
+		CacheMisses:  counts[PerfCacheMisses],
+		TaskClockMs:  float64(counts[PerfTaskClock]) / float64(time.Millisecond),
+		PageFaults:   counts[PerfPageFaults],
+	}
+	if entry.Cycles > 0 {
+		entry.IPC = float64(entry.Instructions) / float64(entry.Cycles)
+	}
+	if entry.Instructions > 0 {
+		entry.BranchMPKI = 1000.0 * float64(entry.BranchMisses) / float64(entry.Instructions)
+		entry.CacheMPKI = 1000.0 * float64(entry.CacheMisses) / float64(entry.Instructions)
+	}
+	return entry
+}
+
+// ProfileSnapshot is a copy of the profiler results that can be printed or
+// exported. Entries are in depth-first order, parents before their children.
+type ProfileSnapshot struct {
+	CPUFreq         uint64         `json:"cpuFreq"`
+	TimerSource     string         `json:"timerSource"`
+	TotalTSC        uint64         `json:"totalTsc"`
+	TotalMs         float64        `json:"totalMs"`
+	OS              *OSEntry       `json:"os,omitempty"`         // session totals, only when the profiler captured OS metrics
+	Perf            *PerfEntry     `json:"perf,omitempty"`       // totals for the profiled thread, only when it had perf counters
+	PerfEvents      []string       `json:"perfEvents,omitempty"` // the perf events that could be opened
+	PerfNote        string         `json:"perfNote,omitempty"`   // why some or all perf events are missing
+	Overhead        BlockOverhead  `json:"overhead"`
+	CounterOverhead BlockOverhead  `json:"counterOverhead"`              // for the hits that read counters, see Profiler.CounterOverhead
+	Subtracted      bool           `json:"overheadSubtracted,omitempty"` // Overhead was taken out of the times and the total
+	Entries         []ProfileEntry `json:"entries"`
+}
+
+// SnapshotProfile is Snapshot of GlobalProfiler.
+func SnapshotProfile() ProfileSnapshot {
+	return GlobalProfiler.Snapshot()
+}
+
+// Snapshot copies every anchor that was hit since Begin. It estimates the CPU
+// frequency, so it can take ~100ms.
+func (p *Profiler) Snapshot() ProfileSnapshot {
+	var snapshot ProfileSnapshot
+	snapshot.CPUFreq = EstimateCPUFrequency()
+	snapshot.TimerSource = CPUTimerSource()
+	snapshot.TotalTSC = p.EndTSC.Load() - p.StartTSC.Load()
+	snapshot.Overhead = p.Overhead
+	snapshot.CounterOverhead = p.CounterOverhead
+	snapshot.Subtracted = p.SubtractOverhead
+	if p.CaptureOSMetrics {
+		snapshot.OS = newOSEntry(p.EndOS.Sub(p.StartOS))
+	}
+	snapshot.PerfNote = p.PerfNote
+	if session := p.perf; session != nil {
+		for event, available := range session.available {
+			if available {
+				snapshot.PerfEvents = append(snapshot.PerfEvents, perfEventNames[event])
+			}
+		}
+		snapshot.Perf = newPerfEntry(p.EndPerf.Sub(p.StartPerf))
+	}
+
+	anchorCount := min(int(p.Counter.Load()), len(p.Anchors))
+	children := make([][]int32, anchorCount)
+	for i := 1; i < anchorCount; i++ {
+		parent := p.Anchors[i].Parent
+		children[parent] = append(children[parent], int32(i))
+	}
+
+	// Estimate how much profiler overhead each anchor's times hold from the
+	// blocks opened in and under it, each hit costing Overhead or, if it read
+	// the counters, CounterOverhead. Children always come after their parent.
+	selfCost := make([]uint64, anchorCount)       // the anchor's own hits, in its own time
+	childCost := make([]uint64, anchorCount)      // blocks opened directly in the anchor, in its exclusive time
+	descendantCost := make([]uint64, anchorCount) // blocks opened anywhere below it, in its inclusive time
+	for i := anchorCount - 1; i > 0; i-- {
+		counterHits := p.Anchors[i].CounterHitCount.Load()
+		plainHits := p.Anchors[i].HitCount.Load() - min(counterHits, p.Anchors[i].HitCount.Load())
+		selfCost[i] = plainHits*snapshot.Overhead.Self + counterHits*snapshot.CounterOverhead.Self
+		parentCost := plainHits*snapshot.Overhead.Parent + counterHits*snapshot.CounterOverhead.Parent
+
+		parent := p.Anchors[i].Parent
+		childCost[parent] += parentCost
+		descendantCost[parent] += selfCost[i] + parentCost + descendantCost[i]
+	}
+	if snapshot.Subtracted {
+		snapshot.TotalTSC -= min(descendantCost[0], snapshot.TotalTSC)
+	}
+	snapshot.TotalMs = tscToMs(snapshot.TotalTSC, snapshot.CPUFreq)
+
+	var visit func(index int32, path string, depth int)
+	visit = func(index int32, path string, depth int) {
+		for _, child := range children[index] {
+			anchor := &p.Anchors[child]
+			childPath := anchor.Label
+			if path != "" {
+				childPath = path + "/" + anchor.Label
+			}
+			if anchor.HitCount.Load() > 0 {
+				exclusiveOverhead := selfCost[child] + childCost[child]
+				inclusiveOverhead := selfCost[child] + descendantCost[child]
+				snapshot.Entries = append(snapshot.Entries, snapshotEntry(anchor, childPath, depth, snapshot, exclusiveOverhead, inclusiveOverhead))
+			}
+			visit(child, childPath, depth+1)
+		}
+	}
+	visit(0, "", 1)
+	return snapshot
+}
+
+func snapshotEntry(anchor *ProfileAnchor, path string, depth int, snapshot ProfileSnapshot, exclusiveOverhead uint64, inclusiveOverhead uint64) ProfileEntry {
+	entry := ProfileEntry{
+		Label:           anchor.Label,
+		Path:            path,
+		Depth:           depth,
+		HitCount:        anchor.HitCount.Load(),
+		CounterHitCount: anchor.CounterHitCount.Load(),
+		TSCExclusive:    anchor.TSCElapsedExclusive.Load(),
+		TSCInclusive:    anchor.TSCElapsedInclusive.Load(),
+		ProcessedBytes:  anchor.ProcessedByteCount.Load(),
+	}
+	if snapshot.OS != nil {
+		entry.OS = newOSEntry(anchor.OS.load())
+	}
+	if snapshot.Perf != nil {
+		entry.Perf = newPerfEntry(anchor.Perf.load())
+	}
+	entry.OverheadTSC = min(exclusiveOverhead, entry.TSCExclusive)
+	if snapshot.Subtracted {
+		entry.TSCExclusive -= entry.OverheadTSC
+		entry.TSCInclusive -= min(inclusiveOverhead, entry.TSCInclusive)
+	}
+	if entry.TSCExclusive > snapshot.TotalTSC {
+		entry.TSCExclusive = snapshot.TotalTSC
+		entry.Clamped = true
+	}
+	entry.ExclusiveMs = tscToMs(entry.TSCExclusive, snapshot.CPUFreq)
+	entry.InclusiveMs = tscToMs(entry.TSCInclusive, snapshot.CPUFreq)
+	if snapshot.TotalTSC > 0 {
+		entry.Percent = 100.0 * float64(entry.TSCExclusive) / float64(snapshot.TotalTSC)
+		entry.PercentWithChildren = 100.0 * float64(entry.TSCInclusive) / float64(snapshot.TotalTSC)
+	}
+	if entry.ProcessedBytes > 0 {
+		const megabyte = 1024.0 * 1024.0
+		const gigabyte = megabyte * 1024.0
+		entry.Megabytes = float64(entry.ProcessedBytes) / megabyte
+		if entry.InclusiveMs > 0 {
+			entry.GigabytesPerSecond = (float64(entry.ProcessedBytes) / gigabyte) / (entry.InclusiveMs / 1000.0)
+		}
+	}
+	return entry
+}
+
+func tscToMs(tsc uint64, cpuFreq uint64) float64 {
+	if cpuFreq == 0 {
+		return 0
+	}
+	return 1000.0 * float64(tsc) / float64(cpuFreq)
+}
+
+// WriteProfileJSON writes the snapshot as an indented JSON object.
+func WriteProfileJSON(w io.Writer, snapshot ProfileSnapshot) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
+
+// WriteProfileCSV writes one row per entry. The session totals are repeated on
+// every row so each row stands on its own when files are concatenated.
+func WriteProfileCSV(w io.Writer, snapshot ProfileSnapshot) error {
+	writer := csv.NewWriter(w)
+	writer.Write(profileCSVHeader)
+	writeProfileCSVRows(writer, snapshot)
+	writer.Flush()
+	return writer.Error()
+}
+
+var profileCSVHeader = []string{
+	"path", "depth", "label", "hit_count", "tsc_exclusive", "tsc_inclusive", "exclusive_ms", "inclusive_ms",
+	"percent", "percent_with_children", "processed_bytes", "gb_per_sec", "cpu_freq", "total_tsc", "total_ms",
+	"minor_faults", "major_faults", "voluntary_switches", "involuntary_switches", "user_ms", "system_ms",
+	"instructions", "cycles", "branch_misses", "cache_misses", "task_clock_ms", "page_faults", "ipc", "branch_mpki", "cache_mpki",
+	"overhead_tsc",
+}
+
+// writeProfileCSVRows writes the rows of WriteProfileCSV, each starting with
+// the prefix columns.
+func writeProfileCSVRows(writer *csv.Writer, snapshot ProfileSnapshot, prefix ...string) {
+	for _, entry := range snapshot.Entries {
+		osColumns := make([]string, 6) // left empty when OS metrics weren't captured
+		if entry.OS != nil {
+			osColumns = []string{
+				strconv.FormatUint(entry.OS.MinorFaults, 10),
+				strconv.FormatUint(entry.OS.MajorFaults, 10),
+				strconv.FormatUint(entry.OS.VoluntarySwitches, 10),
+				strconv.FormatUint(entry.OS.InvoluntarySwitches, 10),
+				strconv.FormatFloat(entry.OS.UserMs, 'f', 3, 64),
+				strconv.FormatFloat(entry.OS.SystemMs, 'f', 3, 64),
+			}
+		}
+		perfColumns := make([]string, 9) // left empty without perf counters
+		if perf := entry.Perf; perf != nil {
+			perfColumns = []string{
+				strconv.FormatUint(perf.Instructions, 10),
+				strconv.FormatUint(perf.Cycles, 10),
+				strconv.FormatUint(perf.BranchMisses, 10),
+				strconv.FormatUint(perf.CacheMisses, 10),
+				strconv.FormatFloat(perf.TaskClockMs, 'f', 3, 64),
+				strconv.FormatUint(perf.PageFaults, 10),
+				strconv.FormatFloat(perf.IPC, 'f', 3, 64),
+				strconv.FormatFloat(perf.BranchMPKI, 'f', 3, 64),
+				strconv.FormatFloat(perf.CacheMPKI, 'f', 3, 64),
+			}
+		}
+		writer.Write(slices.Concat(prefix, []string{
+			entry.Path,
+			strconv.Itoa(entry.Depth),
+			entry.Label,
+			strconv.FormatUint(entry.HitCount, 10),
+			strconv.FormatUint(entry.TSCExclusive, 10),
+			strconv.FormatUint(entry.TSCInclusive, 10),
+			strconv.FormatFloat(entry.ExclusiveMs, 'f', 6, 64),
+			strconv.FormatFloat(entry.InclusiveMs, 'f', 6, 64),
+			strconv.FormatFloat(entry.Percent, 'f', 4, 64),
+			strconv.FormatFloat(entry.PercentWithChildren, 'f', 4, 64),
+			strconv.FormatUint(entry.ProcessedBytes, 10),
+			strconv.FormatFloat(entry.GigabytesPerSecond, 'f', 4, 64),
+			strconv.FormatUint(snapshot.CPUFreq, 10),
+			strconv.FormatUint(snapshot.TotalTSC, 10),
+			strconv.FormatFloat(snapshot.TotalMs, 'f', 6, 64),
+		}, osColumns, perfColumns, []string{strconv.FormatUint(entry.OverheadTSC, 10)}))
+	}
+}
+
+// CheckProfileFormat returns an error unless format is one WriteProfile knows.
+func CheckProfileFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON, FormatCSV:
+		return nil
+	default:
+		return fmt.Errorf("unknown profile format %q, must be '%s', '%s' or '%s'", format, FormatText, FormatJSON, FormatCSV)
+	}
+}
+
+// WriteProfile writes the snapshot in one of FormatText, FormatJSON or FormatCSV.
+func WriteProfile(w io.Writer, snapshot ProfileSnapshot, format string) error {
+	switch format {
+	case FormatText:
+		PrintProfile(w, snapshot)
+		return nil
+	case FormatJSON:
+		return WriteProfileJSON(w, snapshot)
+	case FormatCSV:
+		return WriteProfileCSV(w, snapshot)
+	default:
+		return CheckProfileFormat(format)
+	}
+}
+
+// NamedProfile is one of several snapshots written together by WriteProfiles.
+type NamedProfile struct {
+	Name    string          `json:"name"`
+	Profile ProfileSnapshot `json:"profile"`
+}
+
+// WriteProfiles writes several snapshots, such as repetitions or variants of
+// one run, so they can be compared: side by side with PrintProfileComparison
+// in FormatText, as an array in FormatJSON, and in FormatCSV as one table
+// with the name in an extra first column.
+func WriteProfiles(w io.Writer, profiles []NamedProfile, format string) error {
+	switch format {
+	case FormatText:
+		PrintProfileComparison(w, profiles)
+		return nil
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(profiles)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(slices.Concat([]string{"name"}, profileCSVHeader))
+		for _, profile := range profiles {
+			writeProfileCSVRows(writer, profile.Profile, profile.Name)
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return CheckProfileFormat(format)
+	}
+}
+
+// PrintProfileComparison prints the exclusive time of every block in each
+// profile, one column per profile, with the change from the first profile.
+// Rows follow the first profile's tree; blocks it doesn't have come last.
+func PrintProfileComparison(w io.Writer, profiles []NamedProfile) {
+	if len(profiles) == 0 {
+		return
+	}
+	type row struct {
+		title string
+		ms    []float64 // exclusive ms per profile, -1 where the block is missing
+	}
+	var rows []row
+	rowIndex := make(map[string]int)
+
+	total := row{title: "Total", ms: make([]float64, len(profiles))}
+	for column, profile := range profiles {
+		total.ms[column] = profile.Profile.TotalMs
+		for _, entry := range profile.Profile.Entries {
+			index, ok := rowIndex[entry.Path]
+			if !ok {
+				index = len(rows)
+				rowIndex[entry.Path] = index
+				ms := make([]float64, len(profiles))
+				for i := range ms {
+					ms[i] = -1
+				}
+				rows = append(rows, row{title: strings.Repeat("  ", entry.Depth) + entry.Label, ms: ms})
+			}
+			rows[index].ms[column] = entry.ExclusiveMs
+		}
+	}
+	rows = append([]row{total}, rows...)
+
+	titleWidth := 0
+	for _, r := range rows {
+		titleWidth = max(titleWidth, len(r.title))
+	}
+	const cellWidth = 20
+
+	fmt.Fprintf(w, "\nExclusive time, change from %s\n%-*s", profiles[0].Name, titleWidth, "")
+	for _, profile := range profiles {
+		fmt.Fprintf(w, " %*s", cellWidth, profile.Name)
+	}
+	fmt.Fprintf(w, "\n")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%-*s", titleWidth, r.title)
+		for column, ms := range r.ms {
+			cell := "-"
+			switch {
+			case ms < 0:
+			case column == 0 || r.ms[0] <= 0:
+				cell = fmt.Sprintf("%.3fms", ms)
+			default:
+				cell = fmt.Sprintf("%.3fms %+6.1f%%", ms, 100*(ms-r.ms[0])/r.ms[0])
+			}
+			fmt.Fprintf(w, " %*s", cellWidth, cell)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// EndAndExportProfile ends the profiling session and writes the results to
+// path, or to stdout when path is empty.
+func EndAndExportProfile(format string, path string) error {
+	snapshot := EndProfile()
+	if path == "" {
+		return WriteProfile(os.Stdout, snapshot, format)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = WriteProfile(file, snapshot, format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package timing
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// OSMetrics are the resource counters the OS keeps for the whole process.
+// They are process wide, so blocks running concurrently on other goroutines
+// show up in each other's deltas.
+type OSMetrics struct {
+	MinorFaults         uint64 // page faults served without I/O
+	MajorFaults         uint64 // page faults that had to read from disk
+	VoluntarySwitches   uint64 // context switches while waiting, e.g. on I/O
+	InvoluntarySwitches uint64 // context switches forced by the scheduler
+	UserTime            time.Duration
+	SystemTime          time.Duration
+}
+
+// Sub returns the counters accumulated between start and m.
+func (m OSMetrics) Sub(start OSMetrics) OSMetrics {
+	return OSMetrics{
+		MinorFaults:         m.MinorFaults - start.MinorFaults,
+		MajorFaults:         m.MajorFaults - start.MajorFaults,
+		VoluntarySwitches:   m.VoluntarySwitches - start.VoluntarySwitches,
+		InvoluntarySwitches: m.InvoluntarySwitches - start.InvoluntarySwitches,
+		UserTime:            m.UserTime - start.UserTime,
+		SystemTime:          m.SystemTime - start.SystemTime,
+	}
+}
+
+// osCounters accumulates OSMetrics deltas for one anchor.
+type osCounters struct {
+	minorFaults         atomic.Uint64
+	majorFaults         atomic.Uint64
+	voluntarySwitches   atomic.Uint64
+	involuntarySwitches atomic.Uint64
+	userTime            atomic.Int64
+	systemTime          atomic.Int64
+}
+
+func (c *osCounters) add(delta OSMetrics) {
+	c.minorFaults.Add(delta.MinorFaults)
+	c.majorFaults.Add(delta.MajorFaults)
+	c.voluntarySwitches.Add(delta.VoluntarySwitches)
+	c.involuntarySwitches.Add(delta.InvoluntarySwitches)
+	c.userTime.Add(int64(delta.UserTime))
+	c.systemTime.Add(int64(delta.SystemTime))
+}
+
+func (c *osCounters) load() OSMetrics {
+	return OSMetrics{
+		MinorFaults:         c.minorFaults.Load(),
+		MajorFaults:         c.majorFaults.Load(),
+		VoluntarySwitches:   c.voluntarySwitches.Load(),
+		InvoluntarySwitches: c.involuntarySwitches.Load(),
+		UserTime:            time.Duration(c.userTime.Load()),
+		SystemTime:          time.Duration(c.systemTime.Load()),
+	}
+}
+
+func (c *osCounters) reset() {
+	c.minorFaults.Store(0)
+	c.majorFaults.Store(0)
+	c.voluntarySwitches.Store(0)
+	c.involuntarySwitches.Store(0)
+	c.userTime.Store(0)
+	c.systemTime.Store(0)
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package timing
+
+// OSMetricsSupported reports whether ReadOSMetrics returns real counters.
+const OSMetricsSupported = false
+
+// ReadOSPageFaultCount is not available on this platform and always returns 0.
+func ReadOSPageFaultCount() uint64 {
+	return 0
+}
+
+// ReadOSMetrics is not available on this platform and always returns zeros.
+func ReadOSMetrics() OSMetrics {
+	return OSMetrics{}
+}
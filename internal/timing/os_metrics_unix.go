@@ -0,0 +1,33 @@
+//go:build unix
+
+package timing
+
+import (
+	"syscall"
+	"time"
+)
+
+// OSMetricsSupported reports whether ReadOSMetrics returns real counters.
+const OSMetricsSupported = true
+
+// ReadOSPageFaultCount returns the minor plus major page faults of the process so far.
+func ReadOSPageFaultCount() uint64 {
+	metrics := ReadOSMetrics()
+	return metrics.MinorFaults + metrics.MajorFaults
+}
+
+// ReadOSMetrics returns the process counters so far, from getrusage.
+func ReadOSMetrics() OSMetrics {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return OSMetrics{}
+	}
+	return OSMetrics{
+		MinorFaults:         uint64(usage.Minflt),
+		MajorFaults:         uint64(usage.Majflt),
+		VoluntarySwitches:   uint64(usage.Nvcsw),
+		InvoluntarySwitches: uint64(usage.Nivcsw),
+		UserTime:            time.Duration(usage.Utime.Nano()),
+		SystemTime:          time.Duration(usage.Stime.Nano()),
+	}
+}
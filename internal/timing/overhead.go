@@ -0,0 +1,76 @@
+//go:build !timing
+
+package timing
+
+import (
+	"slices"
+	"sync"
+)
+
+// calibrationRuns is how many empty blocks BeginProfile times to estimate
+// what the profiler itself costs.
+const calibrationRuns = 1000
+
+// BlockOverhead is what timing one block adds to the profile, in CpuTimer
+// ticks. A block's own elapsed time includes Self; the rest of its cost,
+// Parent, lands in the exclusive time of the block it was opened in.
+type BlockOverhead struct {
+	Self   uint64 `json:"self"`
+	Parent uint64 `json:"parent"`
+}
+
+// calibrationProfiler is where the calibration blocks are timed, so they
+// don't show up in the real profile.
+var calibrationProfiler = sync.OnceValue(func() *Profiler {
+	return NewProfiler()
+})
+
+// calibrateOverhead returns the median cost of an empty block that doesn't
+// read any counters, and, when p captures OS metrics or perf counters, that of
+// one reading them the way p's blocks do. Static anchors skip the counters, so
+// a profile can hold both kinds of blocks.
+func (p *Profiler) calibrateOverhead() (plain BlockOverhead, counters BlockOverhead) {
+	scratch := calibrationProfiler()
+	plain = scratch.calibrateBlocks()
+	if p.CaptureOSMetrics || p.perf != nil {
+		scratch.CaptureOSMetrics = p.CaptureOSMetrics
+		scratch.perf = p.perf
+		counters = scratch.calibrateBlocks()
+		scratch.CaptureOSMetrics = false
+		scratch.perf = nil
+	}
+	return plain, counters
+}
+
+// calibrateBlocks times calibrationRuns empty blocks with p's counter
+// settings and returns the median cost.
+func (p *Profiler) calibrateBlocks() BlockOverhead {
+	context := Context{profiler: p, stack: make([]int32, 1, 4), perf: true}
+
+	warmUp := context.open("calibration", 0)
+	context.close(warmUp)
+	anchor := &p.Anchors[warmUp.anchorIndex]
+
+	selfCosts := make([]uint64, calibrationRuns)
+	totalCosts := make([]uint64, calibrationRuns)
+	for i := range calibrationRuns {
+		// Two back to back timer reads: the part of the outer measurement
+		// that isn't the block
+		emptyStart := CpuTimer()
+		emptyEnd := CpuTimer()
+		selfBefore := anchor.TSCElapsedExclusive.Load()
+
+		start := CpuTimer()
+		context.close(context.open("calibration", 0))
+		end := CpuTimer()
+
+		selfCosts[i] = anchor.TSCElapsedExclusive.Load() - selfBefore
+		totalCosts[i] = (end - start) - min(emptyEnd-emptyStart, end-start)
+	}
+
+	slices.Sort(selfCosts)
+	slices.Sort(totalCosts)
+	self := selfCosts[calibrationRuns/2]
+	total := totalCosts[calibrationRuns/2]
+	return BlockOverhead{Self: self, Parent: total - min(self, total)}
+}
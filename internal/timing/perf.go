@@ -0,0 +1,72 @@
+package timing
+
+import "sync/atomic"
+
+// Perf events counted when Profiler.CapturePerfCounters is set, in PerfCounts
+// order. The first four are hardware events, the rest are kernel software
+// events that work in VMs and containers too.
+const (
+	PerfInstructions = iota
+	PerfCycles
+	PerfBranchMisses
+	PerfCacheMisses
+	PerfTaskClock // nanoseconds the thread was on a CPU
+	PerfPageFaults
+	perfEventCount
+)
+
+var perfEventNames = [perfEventCount]string{
+	PerfInstructions: "instructions",
+	PerfCycles:       "cycles",
+	PerfBranchMisses: "branch-misses",
+	PerfCacheMisses:  "cache-misses",
+	PerfTaskClock:    "task-clock",
+	PerfPageFaults:   "page-faults",
+}
+
+// PerfCounts holds a value for each perf event, indexed by the Perf constants.
+type PerfCounts [perfEventCount]uint64
+
+// Sub returns the counts accumulated between start and c.
+func (c PerfCounts) Sub(start PerfCounts) PerfCounts {
+	var delta PerfCounts
+	for i := range c {
+		delta[i] = c[i] - start[i]
+	}
+	return delta
+}
+
+// perfSession is the set of perf event groups open on the profiled thread.
+// Events of a group are read together with one syscall.
+type perfSession struct {
+	groups    []perfGroup
+	available [perfEventCount]bool
+}
+
+type perfGroup struct {
+	fds    []int // fds[0] is the group leader
+	events []int // the Perf event of each fd, in read order
+}
+
+// perfAnchorCounts accumulates PerfCounts deltas for one anchor.
+type perfAnchorCounts [perfEventCount]atomic.Uint64
+
+func (c *perfAnchorCounts) add(delta PerfCounts) {
+	for i := range c {
+		c[i].Add(delta[i])
+	}
+}
+
+func (c *perfAnchorCounts) load() PerfCounts {
+	var counts PerfCounts
+	for i := range c {
+		counts[i] = c[i].Load()
+	}
+	return counts
+}
+
+func (c *perfAnchorCounts) reset() {
+	for i := range c {
+		c[i].Store(0)
+	}
+}
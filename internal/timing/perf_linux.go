@@ -0,0 +1,151 @@
+//go:build linux
+
+package timing
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// perfEventAttr is the first version (PERF_ATTR_SIZE_VER0) of struct
+// perf_event_attr, which every kernel with perf_event_open accepts.
+type perfEventAttr struct {
+	Type         uint32
+	Size         uint32
+	Config       uint64
+	SamplePeriod uint64
+	SampleType   uint64
+	ReadFormat   uint64
+	Flags        uint64
+	WakeupEvents uint32
+	BpType       uint32
+	Config1      uint64
+}
+
+const (
+	perfTypeHardware = 0
+	perfTypeSoftware = 1
+
+	perfFormatTotalTimeEnabled = 1 << 0
+	perfFormatTotalTimeRunning = 1 << 1
+	perfFormatGroup            = 1 << 3
+
+	perfFlagExcludeKernel = 1 << 5
+	perfFlagExcludeHV     = 1 << 6
+
+	perfFlagFDCloexec = 1 << 3
+)
+
+// perfEventConfigs gives the perf_event_attr type and config of each event.
+var perfEventConfigs = [perfEventCount][2]uint64{
+	PerfInstructions: {perfTypeHardware, 1}, // PERF_COUNT_HW_INSTRUCTIONS
+	PerfCycles:       {perfTypeHardware, 0}, // PERF_COUNT_HW_CPU_CYCLES
+	PerfBranchMisses: {perfTypeHardware, 5}, // PERF_COUNT_HW_BRANCH_MISSES
+	PerfCacheMisses:  {perfTypeHardware, 3}, // PERF_COUNT_HW_CACHE_MISSES
+	PerfTaskClock:    {perfTypeSoftware, 1}, // PERF_COUNT_SW_TASK_CLOCK
+	PerfPageFaults:   {perfTypeSoftware, 2}, // PERF_COUNT_SW_PAGE_FAULTS
+}
+
+// openPerfSession opens the hardware and the software events as two groups
+// counting the calling thread in user space, so they need no privileges
+// beyond the default perf_event_paranoid. Hardware events that can't be
+// opened are left out and reported in note; err is only set when no event
+// could be opened at all.
+func openPerfSession() (session *perfSession, note string, err error) {
+	session = &perfSession{}
+	var hardwareErr, softwareErr error
+	hardware := session.openGroup([]int{PerfInstructions, PerfCycles, PerfBranchMisses, PerfCacheMisses}, &hardwareErr)
+	software := session.openGroup([]int{PerfTaskClock, PerfPageFaults}, &softwareErr)
+
+	if len(hardware.fds) == 0 && len(software.fds) == 0 {
+		return nil, "", fmt.Errorf("perf_event_open: %w", errors.Join(hardwareErr, softwareErr))
+	}
+	for _, group := range []perfGroup{hardware, software} {
+		if len(group.fds) > 0 {
+			session.groups = append(session.groups, group)
+		}
+	}
+
+	switch {
+	case len(hardware.fds) == 0:
+		note = fmt.Sprintf("hardware counters unavailable (%v), software events only", hardwareErr)
+	case hardwareErr != nil:
+		note = fmt.Sprintf("some hardware counters unavailable (%v)", hardwareErr)
+	}
+	if softwareErr != nil {
+		note = joinNote(note, fmt.Sprintf("some software events unavailable (%v)", softwareErr))
+	}
+	return session, note, nil
+}
+
+func joinNote(a string, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "; " + b
+}
+
+// openGroup opens as many of events as it can into one group, remembering
+// them as available. The first error is stored in firstErr.
+func (s *perfSession) openGroup(events []int, firstErr *error) perfGroup {
+	var group perfGroup
+	for _, event := range events {
+		attr := perfEventAttr{
+			Type:       uint32(perfEventConfigs[event][0]),
+			Size:       uint32(unsafe.Sizeof(perfEventAttr{})),
+			Config:     perfEventConfigs[event][1],
+			ReadFormat: perfFormatGroup | perfFormatTotalTimeEnabled | perfFormatTotalTimeRunning,
+			Flags:      perfFlagExcludeKernel | perfFlagExcludeHV,
+		}
+		leader := -1
+		if len(group.fds) > 0 {
+			leader = group.fds[0]
+		}
+		fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN, uintptr(unsafe.Pointer(&attr)),
+			0, uintptr(^uint(0)), uintptr(leader), perfFlagFDCloexec, 0) // this thread, any CPU
+		if errno != 0 {
+			if *firstErr == nil {
+				*firstErr = fmt.Errorf("%s: %w", perfEventNames[event], errno)
+			}
+			continue
+		}
+		group.fds = append(group.fds, int(fd))
+		group.events = append(group.events, event)
+		s.available[event] = true
+	}
+	return group
+}
+
+// read returns the counts so far. When the kernel had to multiplex a group
+// its counts are scaled up to the time it was enabled.
+func (s *perfSession) read() PerfCounts {
+	var counts PerfCounts
+	var buffer [3 + perfEventCount]uint64 // nr, time enabled, time running, values
+	bytes := unsafe.Slice((*byte)(unsafe.Pointer(&buffer[0])), len(buffer)*8)
+	for _, group := range s.groups {
+		n, err := syscall.Read(group.fds[0], bytes)
+		if err != nil || n < 3*8 {
+			continue
+		}
+		enabled, running := buffer[1], buffer[2]
+		for i, event := range group.events[:min(int(buffer[0]), len(group.events))] {
+			value := buffer[3+i]
+			if running > 0 && running < enabled {
+				value = uint64(float64(value) * float64(enabled) / float64(running))
+			}
+			counts[event] = value
+		}
+	}
+	return counts
+}
+
+func (s *perfSession) close() {
+	for _, group := range s.groups {
+		for _, fd := range group.fds {
+			syscall.Close(fd)
+		}
+	}
+	s.groups = nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package timing
+
+import "errors"
+
+func openPerfSession() (*perfSession, string, error) {
+	return nil, "", errors.New("perf_event_open is only available on Linux")
+}
+
+func (s *perfSession) read() PerfCounts {
+	return PerfCounts{}
+}
+
+func (s *perfSession) close() {}
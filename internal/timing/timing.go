@@ -4,6 +4,8 @@ package timing
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"runtime"
 	"strings"
 	"sync"
@@ -17,7 +19,20 @@ func OsTimer() int64 {
 	return time.Now().UnixNano()
 }
 
+// CPUTimerSource names the counter behind CpuTimer on this build: "rdtsc",
+// "cntvct_el0" or "os".
+func CPUTimerSource() string {
+	return cpuTimerSource
+}
+
+// EstimateCPUFrequency returns the CpuTimer ticks per second. Counters with a
+// known frequency report it directly, otherwise it is measured against the OS
+// timer for 100ms.
 func EstimateCPUFrequency() uint64 {
+	if freq := cpuTimerFreq(); freq > 0 {
+		return freq
+	}
+
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -52,78 +67,325 @@ func PrintTimeElapsed(label string, totalTSCElapsed uint64, begin uint64, end ui
 	fmt.Printf("   %-15s: %d (%.2f%%)\n", label, elapsed, percent)
 }
 
-// ProfileAnchor stores timing information for a code block.
+// ProfileAnchor stores timing information for one node of the call tree: a
+// label reached through a particular parent.
 type ProfileAnchor struct {
 	TSCElapsedExclusive atomic.Uint64
 	TSCElapsedInclusive atomic.Uint64
 	HitCount            atomic.Uint64
+	CounterHitCount     atomic.Uint64 // hits that also read the OS metrics or perf counters
+	ProcessedByteCount  atomic.Uint64
+	OS                  osCounters       // inclusive OSMetrics deltas, when Profiler.CaptureOSMetrics is set
+	Perf                perfAnchorCounts // inclusive PerfCounts deltas, when Profiler.CapturePerfCounters is set
 	Label               string
+	Parent              int32
+	labelID             int32 // Label interned by internLabel, 0 for the root
 }
 
-// Profiler manages the profiling data.
+type anchorKey struct {
+	parent int32
+	label  string
+}
+
+// Labels are interned process wide, so a context can spot a recursive block by
+// comparing small integers instead of strings.
+var (
+	labelIDs   sync.Map // label -> int32
+	labelCount atomic.Int32
+)
+
+// internLabel returns the ID of label, starting at 1.
+func internLabel(label string) int32 {
+	if id, ok := labelIDs.Load(label); ok {
+		return id.(int32)
+	}
+	id, _ := labelIDs.LoadOrStore(label, labelCount.Add(1))
+	return id.(int32)
+}
+
+// Profiler manages the profiling data of one session at a time. Create
+// profilers with NewProfiler, or use GlobalProfiler.
 type Profiler struct {
-	Anchors       [4096]ProfileAnchor
-	AnchorMap     sync.Map
-	StartTSC      atomic.Uint64
-	EndTSC        atomic.Uint64
-	Counter       atomic.Int32 // Use atomic for concurrent access
-	currentParent atomic.Int32 // Use atomic for currentParent
+	Anchors   [4096]ProfileAnchor
+	AnchorMap sync.Map // anchorKey -> anchor index
+	StartTSC  atomic.Uint64
+	EndTSC    atomic.Uint64
+	Counter   atomic.Int32 // Use atomic for concurrent access
+
+	// CaptureOSMetrics makes every block also read the OS resource counters
+	// when it opens and closes. That is two getrusage calls per block, so it
+	// is off by default; set it before BeginProfile.
+	CaptureOSMetrics bool
+	StartOS          OSMetrics
+	EndOS            OSMetrics
+
+	// CapturePerfCounters opens perf_event_open counters at BeginProfile and
+	// reads them around every block of the default context. The counters
+	// follow one OS thread, so BeginProfile locks its goroutine to the thread
+	// until EndProfile and blocks in other contexts are not counted. PerfNote
+	// says which events could not be opened.
+	CapturePerfCounters bool
+	PerfNote            string
+	StartPerf           PerfCounts
+	EndPerf             PerfCounts
+	perf                *perfSession
+
+	// Overhead is the per-block cost BeginProfile calibrated, and
+	// CounterOverhead that of blocks that also read the OS metrics or perf
+	// counters (zero when neither is captured). Snapshots always estimate how
+	// much of each block's time they account for, using the one that matches
+	// each hit, and take it out of the times when SubtractOverhead is set.
+	Overhead         BlockOverhead
+	CounterOverhead  BlockOverhead
+	SubtractOverhead bool
+
+	addMutex       sync.Mutex    // serializes adding new anchors
+	epoch          atomic.Uint32 // changes at every Reset
+	defaultContext Context
+	ownerTop       atomic.Int32 // the block the default context has open, for NewContext
+}
+
+// Context is a stack of open blocks. Blocks opened through one context nest
+// under each other, so a context must only be used by one goroutine at a time.
+// The package level TimeBlock/TimeFunction/Anchor.Begin use the profiler's
+// default context, which belongs to the goroutine that began the session.
+// Calling them from any other goroutine is a data race: other goroutines get
+// their own context with NewContext and time through its methods and
+// Anchor.BeginIn, as SumHaversineDistancesParallel does.
+type Context struct {
+	profiler *Profiler
+	stack    []int32 // anchor indices; stack[0] is where the context was started
+	perf     bool    // runs on the thread the perf counters follow
+	owner    bool    // the default context: its open block is published in Profiler.ownerTop
 }
 
 // GlobalProfiler is the global instance of the profiler.
 var GlobalProfiler = Profiler{}
 
-// BeginProfile starts the profiling session.
+// activeProfiler receives the blocks of the package level TimeBlock,
+// TimeFunction, NewContext and Anchor.Begin.
+var activeProfiler atomic.Pointer[Profiler]
+
+// profilerEpochs numbers every Reset of every profiler, so Anchor caches can
+// tell which profiler and session an index belongs to.
+var profilerEpochs atomic.Uint32
+
+func init() {
+	// Blocks timed before BeginProfile still need a root to hang off
+	GlobalProfiler.Reset()
+	activeProfiler.Store(&GlobalProfiler)
+}
+
+// NewProfiler returns a profiler separate from GlobalProfiler, so tools can
+// profile several runs in one process and compare the snapshots.
+func NewProfiler() *Profiler {
+	p := new(Profiler)
+	p.Reset()
+	return p
+}
+
+// ActiveProfiler returns the profiler the package level functions time into:
+// the one that was begun last, GlobalProfiler until then.
+func ActiveProfiler() *Profiler {
+	return activeProfiler.Load()
+}
+
+// BeginProfile starts a profiling session on GlobalProfiler.
 func BeginProfile() {
-	GlobalProfiler.StartTSC.Store(CpuTimer())
+	GlobalProfiler.Begin()
+}
 
-	// Initialize the root anchor
-	GlobalProfiler.Anchors[0].Label = "Root"
-	GlobalProfiler.Anchors[0].TSCElapsedExclusive.Store(0)
-	GlobalProfiler.Anchors[0].TSCElapsedInclusive.Store(0)
-	GlobalProfiler.Anchors[0].HitCount.Store(0)
-	GlobalProfiler.AnchorMap.Store("Root", 0)
-	GlobalProfiler.Counter.Store(1)
-	GlobalProfiler.currentParent.Store(0)
+// Begin resets the profiler, makes it the active one and starts a session.
+func (p *Profiler) Begin() {
+	p.Reset()
+	activeProfiler.Store(p)
+	p.beginPerf()
+	if IsTimingEnabled() {
+		p.Overhead, p.CounterOverhead = p.calibrateOverhead()
+	}
 
+	// Start counting once the calibration is done
+	if p.perf != nil {
+		p.StartPerf = p.perf.read()
+	}
+	if p.CaptureOSMetrics {
+		p.StartOS = ReadOSMetrics()
+	}
+	p.StartTSC.Store(CpuTimer())
+}
+
+// End stops the session; Snapshot then reports it.
+func (p *Profiler) End() {
+	p.EndTSC.Store(CpuTimer())
+	if p.CaptureOSMetrics {
+		p.EndOS = ReadOSMetrics()
+	}
+	p.endPerf()
+}
+
+// Reset forgets every block timed so far, leaving the settings alone. No
+// block of the profiler may be open while it runs.
+func (p *Profiler) Reset() {
+	p.addMutex.Lock()
+	defer p.addMutex.Unlock()
+
+	for i := range min(int(p.Counter.Load()), len(p.Anchors)) {
+		p.Anchors[i].reset(0, "", 0)
+	}
+	p.Anchors[0].Label = "Root"
+	p.AnchorMap.Clear()
+	p.Counter.Store(1)
+	p.epoch.Store(profilerEpochs.Add(1))
+	p.StartTSC.Store(0)
+	p.EndTSC.Store(0)
+	p.defaultContext = Context{profiler: p, stack: make([]int32, 1, 64), perf: true, owner: true}
+	p.ownerTop.Store(0)
+}
+
+func (a *ProfileAnchor) reset(parent int32, label string, labelID int32) {
+	a.TSCElapsedExclusive.Store(0)
+	a.TSCElapsedInclusive.Store(0)
+	a.HitCount.Store(0)
+	a.CounterHitCount.Store(0)
+	a.ProcessedByteCount.Store(0)
+	a.OS.reset()
+	a.Perf.reset()
+	a.Label = label
+	a.Parent = parent
+	a.labelID = labelID
+}
+
+// beginPerf opens the perf counters for the session, when they were asked for.
+func (p *Profiler) beginPerf() {
+	p.endPerf()
+	p.perf = nil
+	p.PerfNote = ""
+	p.StartPerf = PerfCounts{}
+	p.EndPerf = PerfCounts{}
+	if !p.CapturePerfCounters {
+		return
+	}
+
+	runtime.LockOSThread()
+	session, note, err := openPerfSession()
+	if err != nil {
+		runtime.UnlockOSThread()
+		p.PerfNote = fmt.Sprintf("perf counters unavailable (%v)", err)
+		return
+	}
+	p.perf = session
+	p.PerfNote = note
+}
+
+// endPerf reads the final perf counts and closes the counters. The session
+// stays in p.perf so the snapshot knows which events were counted.
+func (p *Profiler) endPerf() {
+	if p.perf == nil || len(p.perf.groups) == 0 {
+		return
+	}
+	p.EndPerf = p.perf.read()
+	p.perf.close()
+	runtime.UnlockOSThread()
+}
+
+// NewContext returns a context for another goroutine. Its blocks show up in
+// the tree under whatever block the default context has open right now, but
+// their time is not subtracted from it since they run concurrently. It can be
+// called from any goroutine.
+func NewContext() *Context {
+	p := ActiveProfiler()
+	stack := make([]int32, 1, 16)
+	stack[0] = p.ownerTop.Load()
+	return &Context{profiler: p, stack: stack}
 }
 
 // EndAndPrintProfile ends the profiling session and prints the results.
 func EndAndPrintProfile() {
-	GlobalProfiler.EndTSC.Store(CpuTimer())
+	PrintProfile(os.Stdout, EndProfile())
+}
 
-	cpuFreq := EstimateCPUFrequency()
-	totalCPUElapsed := GlobalProfiler.EndTSC.Load() - GlobalProfiler.StartTSC.Load()
+// EndProfile ends the GlobalProfiler session and returns a snapshot of the results.
+func EndProfile() ProfileSnapshot {
+	GlobalProfiler.End()
+	return GlobalProfiler.Snapshot()
+}
 
-	if cpuFreq > 0 {
-		fmt.Printf("\nTotal time: %.4fms (CPU freq %d)\n", 1000.0*float64(totalCPUElapsed)/float64(cpuFreq), cpuFreq)
+// PrintProfile writes the human readable profile table.
+func PrintProfile(w io.Writer, snapshot ProfileSnapshot) {
+	if snapshot.CPUFreq > 0 {
+		fmt.Fprintf(w, "\nTotal time: %.4fms (CPU freq %d, timer %s)\n", snapshot.TotalMs, snapshot.CPUFreq, snapshot.TimerSource)
 	}
-
-	for i := 0; i < int(GlobalProfiler.Counter.Load()); i++ {
-		anchor := &GlobalProfiler.Anchors[i]
-		if anchor.TSCElapsedExclusive.Load() > 0 {
-			printTimeElapsed(totalCPUElapsed, anchor)
+	if snapshot.Overhead != (BlockOverhead{}) {
+		fmt.Fprintf(w, "Profiler overhead: %d ticks per block (%d in the block, %d in its parent), ",
+			snapshot.Overhead.Self+snapshot.Overhead.Parent, snapshot.Overhead.Self, snapshot.Overhead.Parent)
+		if snapshot.CounterOverhead != (BlockOverhead{}) {
+			fmt.Fprintf(w, "%d (%d, %d) when reading counters, ", snapshot.CounterOverhead.Self+snapshot.CounterOverhead.Parent,
+				snapshot.CounterOverhead.Self, snapshot.CounterOverhead.Parent)
+		}
+		if snapshot.Subtracted {
+			fmt.Fprintf(w, "subtracted from the times below\n")
+		} else {
+			fmt.Fprintf(w, "included in the times below\n")
 		}
 	}
+	if snapshot.OS != nil {
+		fmt.Fprintf(w, "OS counters: %d/%d faults (minor/major), %d/%d switches (voluntary/involuntary), %.3f/%.3fms cpu (user/system)\n",
+			snapshot.OS.MinorFaults, snapshot.OS.MajorFaults, snapshot.OS.VoluntarySwitches, snapshot.OS.InvoluntarySwitches,
+			snapshot.OS.UserMs, snapshot.OS.SystemMs)
+		fmt.Fprintf(w, "Blocks show the same counters in [], including their children\n")
+	}
+	if snapshot.PerfNote != "" {
+		fmt.Fprintf(w, "Perf counters: %s\n", snapshot.PerfNote)
+	}
+	if snapshot.Perf != nil {
+		fmt.Fprintf(w, "Perf counters on the profiled thread (%s):%s\n", strings.Join(snapshot.PerfEvents, ", "), formatPerf(snapshot.Perf))
+		fmt.Fprintf(w, "Blocks show the same counters in {}, including their children\n")
+	}
+
+	for _, entry := range snapshot.Entries {
+		printTimeElapsed(w, entry)
+	}
 }
 
-func printTimeElapsed(totalTSCElapsed uint64, anchor *ProfileAnchor) {
-	exclusive := anchor.TSCElapsedExclusive.Load()
+func printTimeElapsed(w io.Writer, entry ProfileEntry) {
+	if entry.Clamped {
+		fmt.Fprintf(w, "WARNING: Invalid timing for %s - elapsed time exceeds total time\n", entry.Label)
+	}
 
-	if exclusive > totalTSCElapsed {
-		fmt.Printf("WARNING: Invalid timing for %s - elapsed time exceeds total time\n", anchor.Label)
-		exclusive = totalTSCElapsed
+	indent := strings.Repeat("  ", entry.Depth)
+	fmt.Fprintf(w, "%s%s[%d]: %d (%.2f%%", indent, entry.Label, entry.HitCount, entry.TSCExclusive, entry.Percent)
+
+	if entry.TSCInclusive != entry.TSCExclusive {
+		fmt.Fprintf(w, ", %.2f%% w/children", entry.PercentWithChildren)
 	}
+	fmt.Fprintf(w, ")")
 
-	percent := 100.0 * (float64(exclusive) / float64(totalTSCElapsed))
-	fmt.Printf("  %s[%d]: %d (%.2f%%", anchor.Label, anchor.HitCount.Load(), exclusive, percent)
+	if entry.ProcessedBytes > 0 {
+		fmt.Fprintf(w, "  %.3fmb at %.2fgb/s", entry.Megabytes, entry.GigabytesPerSecond)
+	}
+	if entry.OS != nil {
+		fmt.Fprintf(w, "  [faults %d/%d, switches %d/%d, cpu %.3f/%.3fms]",
+			entry.OS.MinorFaults, entry.OS.MajorFaults, entry.OS.VoluntarySwitches, entry.OS.InvoluntarySwitches,
+			entry.OS.UserMs, entry.OS.SystemMs)
+	}
+	if entry.Perf != nil {
+		fmt.Fprintf(w, "  {%s }", formatPerf(entry.Perf))
+	}
+	fmt.Fprintf(w, "\n")
+}
 
-	inclusive := anchor.TSCElapsedInclusive.Load()
-	if inclusive != exclusive {
-		percentWithChildren := 100.0 * (float64(inclusive) / float64(totalTSCElapsed))
-		fmt.Printf(", %.2f%% w/children", percentWithChildren)
+// formatPerf lists the perf counts that were measured, with the ratios that
+// can be built from them.
+func formatPerf(perf *PerfEntry) string {
+	var text strings.Builder
+	if perf.Cycles > 0 {
+		fmt.Fprintf(&text, " ipc %.2f", perf.IPC)
+	}
+	if perf.Instructions > 0 {
+		fmt.Fprintf(&text, " br-miss %.2f/ki cache-miss %.2f/ki", perf.BranchMPKI, perf.CacheMPKI)
 	}
-	fmt.Printf(")\n")
+	fmt.Fprintf(&text, " task %.3fms pf %d", perf.TaskClockMs, perf.PageFaults)
+	return text.String()
 }
 
 var enableTimingStr = "false"
@@ -134,56 +396,180 @@ func IsTimingEnabled() bool {
 
 // TimeBlock is a function that returns a function to stop the timer
 func TimeBlock(label string) func() {
+	return TimeBandwidth(label, 0)
+}
+
+// TimeBandwidth works like TimeBlock and also credits byteCount processed bytes
+// to the block, so the profile can report throughput.
+func TimeBandwidth(label string, byteCount uint64) func() {
 	if !IsTimingEnabled() {
 		return func() {}
 	}
+	return ActiveProfiler().defaultContext.TimeBandwidth(label, byteCount)
+}
 
-	parentIndex := GlobalProfiler.currentParent.Load()
-	anchorIndex := getOrAddAnchor(label)
+// TimeBlock starts a block in this context and returns the function to stop it.
+func (c *Context) TimeBlock(label string) func() {
+	return c.TimeBandwidth(label, 0)
+}
 
-	oldInclusive := GlobalProfiler.Anchors[anchorIndex].TSCElapsedInclusive.Load()
-	GlobalProfiler.currentParent.Store(anchorIndex)
-	startTime := CpuTimer()
+// TimeBandwidth is TimeBlock with byteCount processed bytes credited to the block.
+func (c *Context) TimeBandwidth(label string, byteCount uint64) func() {
+	if !IsTimingEnabled() {
+		return func() {}
+	}
 
+	block := c.open(label, byteCount)
 	return func() {
+		c.close(block)
+	}
+}
 
-		elapsed := CpuTimer() - startTime
-		GlobalProfiler.currentParent.Store(parentIndex)
+// openBlock is the state of a started block until it is closed.
+type openBlock struct {
+	anchorIndex int32
+	parentIndex int32
+	ownsParent  bool // the parent was opened in this context, so our time is not its own
+	outermost   bool // not a recursive activation of a block already open
+	byteCount   uint64
+	captureOS   bool
+	startOS     OSMetrics
+	perf        *perfSession // nil unless the block's perf counts are read
+	startPerf   PerfCounts
+	startTSC    uint64
+}
 
-		anchor := &GlobalProfiler.Anchors[anchorIndex]
-		parent := &GlobalProfiler.Anchors[parentIndex]
+func (c *Context) open(label string, byteCount uint64) openBlock {
+	return c.openAnchor(label, byteCount, nil)
+}
 
-		//1. Subtract elapsed time from parent's exlusive time
-		if parentIndex > 0 {
-			parent.TSCElapsedExclusive.Add(^(elapsed - 1))
+// openAnchor opens a block, finding its anchor through static when it isn't nil.
+func (c *Context) openAnchor(label string, byteCount uint64, static *Anchor) openBlock {
+	if len(c.stack) == 0 {
+		c.stack = append(c.stack, 0)
+	}
+	top := len(c.stack) - 1
+	parentIndex := c.stack[top]
+
+	// The anchor under this parent usually exists already and knows its
+	// label's ID, so only a block's first activation pays for internLabel
+	known := int32(-1)
+	var labelID int32
+	if static != nil {
+		labelID = static.labelID
+	} else if index, ok := c.profiler.AnchorMap.Load(anchorKey{parent: parentIndex, label: label}); ok {
+		known = index.(int32)
+		labelID = c.profiler.Anchors[known].labelID
+	} else {
+		labelID = internLabel(label)
+	}
+
+	// A block that is already open in this context is a recursive call. It
+	// reuses the outer activation's anchor so the tree doesn't grow with the
+	// recursion depth, and only the outermost activation counts inclusive time.
+	anchorIndex := int32(-1)
+	for i := top; i > 0; i-- {
+		if c.profiler.Anchors[c.stack[i]].labelID == labelID {
+			anchorIndex = c.stack[i]
+			break
 		}
+	}
+	outermost := anchorIndex < 0
+	if outermost && static != nil {
+		anchorIndex = static.indexUnder(c.profiler, parentIndex)
+	} else if outermost && known >= 0 {
+		anchorIndex = known
+	} else if outermost {
+		anchorIndex = c.profiler.getOrAddAnchor(parentIndex, label)
+	}
+
+	c.stack = append(c.stack, anchorIndex)
+	if c.owner {
+		c.profiler.ownerTop.Store(anchorIndex)
+	}
+	// Static anchors time hot code and skip the counters unless they opt in
+	counters := outermost && (static == nil || static.counters)
+	block := openBlock{
+		anchorIndex: anchorIndex,
+		parentIndex: parentIndex,
+		ownsParent:  top > 0,
+		outermost:   outermost,
+		byteCount:   byteCount,
+		captureOS:   c.profiler.CaptureOSMetrics && counters,
+	}
+	// Read the OS counters outside the timed region, getrusage is a syscall
+	if block.captureOS {
+		block.startOS = ReadOSMetrics()
+	}
+	if c.perf && counters && c.profiler.perf != nil {
+		block.perf = c.profiler.perf
+		block.startPerf = block.perf.read()
+	}
+	block.startTSC = CpuTimer()
+	return block
+}
+
+func (c *Context) close(block openBlock) {
+	elapsed := CpuTimer() - block.startTSC
+	if block.perf != nil {
+		c.profiler.Anchors[block.anchorIndex].Perf.add(block.perf.read().Sub(block.startPerf))
+	}
+	if block.captureOS {
+		c.profiler.Anchors[block.anchorIndex].OS.add(ReadOSMetrics().Sub(block.startOS))
+	}
+	c.stack = c.stack[:len(c.stack)-1]
+	if c.owner {
+		c.profiler.ownerTop.Store(c.stack[len(c.stack)-1])
+	}
 
-		//2. Add elapsed time to current anchor's exclusive time
-		anchor.TSCElapsedExclusive.Add(elapsed)
+	anchor := &c.profiler.Anchors[block.anchorIndex]
 
-		//3. Set inclusive time (total time including children)
-		anchor.TSCElapsedInclusive.Store(oldInclusive + elapsed)
+	//1. Subtract elapsed time from parent's exclusive time
+	if block.ownsParent {
+		c.profiler.Anchors[block.parentIndex].TSCElapsedExclusive.Add(^(elapsed - 1))
+	}
+
+	//2. Add elapsed time to current anchor's exclusive time
+	anchor.TSCElapsedExclusive.Add(elapsed)
 
-		//4. Increment hit count
-		anchor.HitCount.Add(1)
+	//3. Add inclusive time (total time including children), outermost activation only
+	if block.outermost {
+		anchor.TSCElapsedInclusive.Add(elapsed)
+	}
 
+	//4. Increment hit count, and say what this hit cost the profiler
+	anchor.HitCount.Add(1)
+	if block.captureOS || block.perf != nil {
+		anchor.CounterHitCount.Add(1)
 	}
+
+	//5. Credit processed bytes
+	anchor.ProcessedByteCount.Add(block.byteCount)
 }
 
-func getOrAddAnchor(label string) int32 {
-	val, ok := GlobalProfiler.AnchorMap.Load(label)
+func (p *Profiler) getOrAddAnchor(parent int32, label string) int32 {
+	key := anchorKey{parent: parent, label: label}
+	val, ok := p.AnchorMap.Load(key)
 	if ok {
 		return val.(int32)
 	}
-	newIndex := GlobalProfiler.Counter.Add(1) - 1
-	if int(newIndex) >= len(GlobalProfiler.Anchors) {
+
+	p.addMutex.Lock()
+	defer p.addMutex.Unlock()
+
+	// Another goroutine may have added it while we waited for the lock
+	if val, ok := p.AnchorMap.Load(key); ok {
+		return val.(int32)
+	}
+
+	newIndex := p.Counter.Add(1) - 1
+	if int(newIndex) >= len(p.Anchors) {
 		fmt.Println("Warning: Too many profile blocks, skipping:", label)
 		return 0 // Return a dummy anchor index
 	}
 
-	GlobalProfiler.AnchorMap.Store(label, newIndex)
-	anchor := &GlobalProfiler.Anchors[newIndex]
-	anchor.Label = label
+	p.Anchors[newIndex].reset(parent, label, internLabel(label))
+	p.AnchorMap.Store(key, newIndex)
 	return newIndex
 }
 
@@ -1,8 +1,13 @@
 package timing
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"runtime"
+	"slices"
+	"sync"
 	"testing"
 )
 
@@ -49,3 +54,478 @@ func TestCPUFreq(t *testing.T) {
 	cpuFreq := EstimateCPUFrequency()
 	fmt.Printf("Estimated CPU Frequency: %d\n", cpuFreq)
 }
+
+func TestProfileExport(t *testing.T) {
+	snapshot := ProfileSnapshot{
+		CPUFreq:  1000,
+		TotalTSC: 500,
+		TotalMs:  500,
+		Entries: []ProfileEntry{
+			{Label: "Parse, JSON", Path: "Parse, JSON", Depth: 1, HitCount: 1, TSCExclusive: 100, TSCInclusive: 300},
+			{Label: "Sum", Path: "Parse, JSON/Sum", Depth: 2, HitCount: 2, TSCExclusive: 200, TSCInclusive: 200},
+		},
+	}
+
+	var jsonOut bytes.Buffer
+	if err := WriteProfile(&jsonOut, snapshot, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded ProfileSnapshot
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Entries) != 2 || decoded.Entries[0].Label != "Parse, JSON" || decoded.Entries[1].HitCount != 2 {
+		t.Errorf("JSON round trip lost entries: %+v", decoded)
+	}
+
+	var csvOut bytes.Buffer
+	if err := WriteProfile(&csvOut, snapshot, FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][2] != "Parse, JSON" || records[2][3] != "2" {
+		t.Errorf("unexpected CSV records: %v", records)
+	}
+
+	if err := WriteProfile(&csvOut, snapshot, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func enableTimingForTest(t testing.TB) {
+	old := enableTimingStr
+	enableTimingStr = "true"
+	t.Cleanup(func() { enableTimingStr = old })
+}
+
+func findEntry(snapshot ProfileSnapshot, path string) *ProfileEntry {
+	for i := range snapshot.Entries {
+		if snapshot.Entries[i].Path == path {
+			return &snapshot.Entries[i]
+		}
+	}
+	return nil
+}
+
+func TestRecursiveAndConcurrentBlocks(t *testing.T) {
+	enableTimingForTest(t)
+	BeginProfile()
+
+	var recurse func(depth int)
+	recurse = func(depth int) {
+		defer TimeBlock("recurse")()
+		if depth > 0 {
+			recurse(depth - 1)
+		} else {
+			stop := TimeBlock("leaf")
+			for OsTimer()%1000 != 0 {
+			}
+			stop()
+		}
+	}
+
+	stopOuter := TimeBlock("outer")
+	recurse(3)
+	var wg sync.WaitGroup
+	for range 4 {
+		ctx := NewContext()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				ctx.TimeBlock("worker")()
+			}
+		}()
+	}
+	wg.Wait()
+	stopOuter()
+
+	snapshot := EndProfile()
+
+	outer := findEntry(snapshot, "outer")
+	rec := findEntry(snapshot, "outer/recurse")
+	leaf := findEntry(snapshot, "outer/recurse/leaf")
+	worker := findEntry(snapshot, "outer/worker")
+	if outer == nil || rec == nil || leaf == nil || worker == nil {
+		t.Fatalf("missing tree entries: %+v", snapshot.Entries)
+	}
+	if findEntry(snapshot, "outer/recurse/recurse") != nil {
+		t.Error("recursive calls should fold into the outermost activation")
+	}
+	if rec.HitCount != 4 || leaf.HitCount != 1 || worker.HitCount != 400 {
+		t.Errorf("unexpected hit counts: recurse %d, leaf %d, worker %d", rec.HitCount, leaf.HitCount, worker.HitCount)
+	}
+	if rec.TSCInclusive > outer.TSCInclusive {
+		t.Errorf("recursive inclusive time %d exceeds its parent's %d", rec.TSCInclusive, outer.TSCInclusive)
+	}
+	if rec.TSCExclusive+leaf.TSCInclusive != rec.TSCInclusive {
+		t.Errorf("recurse exclusive %d + leaf %d != recurse inclusive %d", rec.TSCExclusive, leaf.TSCInclusive, rec.TSCInclusive)
+	}
+}
+
+func TestOSMetricsPerBlock(t *testing.T) {
+	if !OSMetricsSupported {
+		t.Skip("no OS metrics on this platform")
+	}
+	enableTimingForTest(t)
+	GlobalProfiler.CaptureOSMetrics = true
+	t.Cleanup(func() { GlobalProfiler.CaptureOSMetrics = false })
+	BeginProfile()
+
+	stop := TimeBlock("touch")
+	memory := make([]byte, 16<<20)
+	for i := 0; i < len(memory); i += 4096 {
+		memory[i] = 1
+	}
+	stop()
+	TimeBlock("untouched")()
+
+	snapshot := EndProfile()
+	touch := findEntry(snapshot, "touch")
+	if snapshot.OS == nil || touch == nil || touch.OS == nil {
+		t.Fatalf("OS metrics missing: %+v", snapshot)
+	}
+	if touch.OS.MinorFaults+touch.OS.MajorFaults == 0 {
+		t.Errorf("touching 16mb took no page faults: %+v", touch.OS)
+	}
+	if touch.OS.MinorFaults > snapshot.OS.MinorFaults {
+		t.Errorf("block faults %d exceed the session's %d", touch.OS.MinorFaults, snapshot.OS.MinorFaults)
+	}
+
+	var csvOut bytes.Buffer
+	if err := WriteProfileCSV(&csvOut, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[0][15] != "minor_faults" || records[1][15] == "" {
+		t.Errorf("unexpected CSV OS columns: %v", records[:2])
+	}
+}
+
+func TestPerfCountersFallBack(t *testing.T) {
+	enableTimingForTest(t)
+	GlobalProfiler.CapturePerfCounters = true
+	t.Cleanup(func() { GlobalProfiler.CapturePerfCounters = false })
+	BeginProfile()
+
+	stop := TimeBlock("spin")
+	for start := OsTimer(); OsTimer()-start < osTimerFreq/100; {
+	}
+	stop()
+
+	snapshot := EndProfile()
+	if snapshot.Perf == nil {
+		if snapshot.PerfNote == "" {
+			t.Fatal("perf counters missing without a note saying why")
+		}
+		t.Skip(snapshot.PerfNote)
+	}
+	spin := findEntry(snapshot, "spin")
+	if spin == nil || spin.Perf == nil {
+		t.Fatalf("perf counts missing from the block: %+v", snapshot.Entries)
+	}
+	if slices.Contains(snapshot.PerfEvents, "task-clock") && spin.Perf.TaskClockMs < 5 {
+		t.Errorf("10ms spin measured %.3fms of task-clock", spin.Perf.TaskClockMs)
+	}
+	if slices.Contains(snapshot.PerfEvents, "cycles") && spin.Perf.IPC <= 0 {
+		t.Errorf("no IPC with cycles counted: %+v", spin.Perf)
+	}
+}
+
+var benchAnchor = NewAnchor("bench")
+
+func TestAnchorMatchesTimeBlock(t *testing.T) {
+	enableTimingForTest(t)
+	BeginProfile()
+
+	stopOuter := TimeBlock("anchor outer")
+	for range 10 {
+		block := benchAnchor.Begin()
+		TimeBlock("inside")()
+		block.End()
+	}
+	stopOuter()
+	benchAnchor.Begin().End()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		benchAnchor.Begin().End()
+	})
+	if allocs != 0 {
+		t.Errorf("Begin/End allocated %.1f times per call", allocs)
+	}
+
+	snapshot := EndProfile()
+	nested := findEntry(snapshot, "anchor outer/bench")
+	top := findEntry(snapshot, "bench")
+	if nested == nil || top == nil || findEntry(snapshot, "anchor outer/bench/inside") == nil {
+		t.Fatalf("anchor blocks missing from the tree: %+v", snapshot.Entries)
+	}
+	if nested.HitCount != 10 || top.HitCount < 101 {
+		t.Errorf("unexpected hit counts: nested %d, top level %d", nested.HitCount, top.HitCount)
+	}
+}
+
+func TestAnchorCountersAndRecursion(t *testing.T) {
+	if !OSMetricsSupported {
+		t.Skip("no OS metrics on this platform")
+	}
+	enableTimingForTest(t)
+	GlobalProfiler.CaptureOSMetrics = true
+	t.Cleanup(func() { GlobalProfiler.CaptureOSMetrics = false })
+	BeginProfile()
+
+	touch := func(anchor *Anchor) {
+		block := anchor.Begin()
+		memory := make([]byte, 16<<20)
+		for i := 0; i < len(memory); i += 4096 {
+			memory[i] = 1
+		}
+		block.End()
+	}
+	touch(NewAnchor("hot"))
+	touch(NewAnchor("counted").CaptureCounters())
+
+	// Indirect recursion through two anchors folds like TimeBlock's
+	outer, inner := NewAnchor("ping"), NewAnchor("pong")
+	var recurse func(depth int)
+	recurse = func(depth int) {
+		block := outer.Begin()
+		if depth > 0 {
+			nested := inner.Begin()
+			recurse(depth - 1)
+			nested.End()
+		}
+		block.End()
+	}
+	recurse(3)
+
+	snapshot := EndProfile()
+	hot, counted := findEntry(snapshot, "hot"), findEntry(snapshot, "counted")
+	if hot == nil || counted == nil || hot.OS == nil || counted.OS == nil {
+		t.Fatalf("anchor entries missing: %+v", snapshot.Entries)
+	}
+	if hot.OS.MinorFaults+hot.OS.MajorFaults != 0 || counted.OS.MinorFaults+counted.OS.MajorFaults == 0 {
+		t.Errorf("faults: %d without CaptureCounters, %d with, want none and some", hot.OS.MinorFaults, counted.OS.MinorFaults)
+	}
+	if hot.CounterHitCount != 0 || counted.CounterHitCount != 1 {
+		t.Errorf("counter hits: %d without CaptureCounters, %d with, want 0 and 1", hot.CounterHitCount, counted.CounterHitCount)
+	}
+
+	ping, pong := findEntry(snapshot, "ping"), findEntry(snapshot, "ping/pong")
+	if ping == nil || pong == nil || findEntry(snapshot, "ping/pong/ping") != nil {
+		t.Fatalf("recursion didn't fold: %+v", snapshot.Entries)
+	}
+	if ping.HitCount != 4 || pong.HitCount != 3 {
+		t.Errorf("unexpected hit counts: ping %d, pong %d", ping.HitCount, pong.HitCount)
+	}
+}
+
+// benchmarkBlocks times b.N empty blocks with timeBlock and reports the
+// cycles (CpuTimer ticks) each one costs, as seen from the enclosing block.
+func benchmarkBlocks(b *testing.B, timeBlock func()) {
+	enableTimingForTest(b)
+	BeginProfile()
+	stop := TimeBlock("benchmark")
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := CpuTimer()
+	for range b.N {
+		timeBlock()
+	}
+	b.ReportMetric(float64(CpuTimer()-start)/float64(b.N), "cycles/op")
+	b.StopTimer()
+	stop()
+}
+
+func BenchmarkTimeBlock(b *testing.B) {
+	benchmarkBlocks(b, func() { TimeBlock("bench")() })
+}
+
+func BenchmarkTimeFunction(b *testing.B) {
+	benchmarkBlocks(b, func() { TimeFunction()() })
+}
+
+func BenchmarkAnchor(b *testing.B) {
+	benchmarkBlocks(b, func() { benchAnchor.Begin().End() })
+}
+
+func TestOverheadCalibration(t *testing.T) {
+	enableTimingForTest(t)
+	GlobalProfiler.SubtractOverhead = true
+	t.Cleanup(func() { GlobalProfiler.SubtractOverhead = false })
+	BeginProfile()
+
+	emptyAnchor := NewAnchor("empty")
+	stop := TimeBlock("parent of empty blocks")
+	for range 1000 {
+		emptyAnchor.Begin().End()
+	}
+	stop()
+
+	snapshot := EndProfile()
+	overhead := snapshot.Overhead
+	if overhead.Self+overhead.Parent == 0 || !snapshot.Subtracted {
+		t.Fatalf("no overhead calibrated: %+v", overhead)
+	}
+	parent := findEntry(snapshot, "parent of empty blocks")
+	empty := findEntry(snapshot, "parent of empty blocks/empty")
+	if parent == nil || empty == nil {
+		t.Fatalf("missing tree entries: %+v", snapshot.Entries)
+	}
+	// Nearly all of an empty block is overhead, so little should be left
+	if ticks := empty.TSCExclusive / empty.HitCount; ticks > overhead.Self+overhead.Parent {
+		t.Errorf("empty blocks still take %d ticks each after subtracting %+v", ticks, overhead)
+	}
+	if limit := 1000*overhead.Parent + overhead.Self; parent.OverheadTSC > limit {
+		t.Errorf("parent overhead %d is more than its own and its 1000 children's %d", parent.OverheadTSC, limit)
+	}
+}
+
+var overheadSink float64
+
+func TestOverheadWithCounters(t *testing.T) {
+	if !OSMetricsSupported {
+		t.Skip("no OS metrics on this platform")
+	}
+	enableTimingForTest(t)
+	GlobalProfiler.CaptureOSMetrics = true
+	GlobalProfiler.SubtractOverhead = true
+	t.Cleanup(func() {
+		GlobalProfiler.CaptureOSMetrics = false
+		GlobalProfiler.SubtractOverhead = false
+	})
+	BeginProfile()
+
+	workAnchor := NewAnchor("work")
+	stop := TimeBlock("counted parent")
+	for range 1000 {
+		block := workAnchor.Begin()
+		for i := range 2000 {
+			overheadSink += float64(i) * 0.5
+		}
+		block.End()
+	}
+	stop()
+
+	snapshot := EndProfile()
+	plain, counters := snapshot.Overhead, snapshot.CounterOverhead
+	if counters.Self+counters.Parent <= plain.Self+plain.Parent {
+		t.Fatalf("reading counters calibrated as no dearer: %+v vs %+v", counters, plain)
+	}
+	parent := findEntry(snapshot, "counted parent")
+	work := findEntry(snapshot, "counted parent/work")
+	if parent == nil || work == nil {
+		t.Fatalf("missing tree entries: %+v", snapshot.Entries)
+	}
+	// The anchor skips the counters, so only the plain overhead comes off it
+	if work.TSCExclusive == 0 || work.OverheadTSC != 1000*plain.Self {
+		t.Errorf("anchor blocks: exclusive %d, overhead %d, want positive with %d ticks of overhead", work.TSCExclusive, work.OverheadTSC, 1000*plain.Self)
+	}
+	if limit := counters.Self + 1000*plain.Parent; parent.OverheadTSC > limit {
+		t.Errorf("parent overhead %d is more than its own and its children's %d", parent.OverheadTSC, limit)
+	}
+	if parent.PercentWithChildren > 100 {
+		t.Errorf("parent is %.1f%% of the total with children", parent.PercentWithChildren)
+	}
+}
+
+func TestSeparateSessions(t *testing.T) {
+	enableTimingForTest(t)
+	t.Cleanup(func() { activeProfiler.Store(&GlobalProfiler) })
+
+	// Sessions of GlobalProfiler start from scratch
+	for range 2 {
+		BeginProfile()
+		for range 3 {
+			benchAnchor.Begin().End()
+			TimeBlock("closure")()
+		}
+		snapshot := EndProfile()
+		if len(snapshot.Entries) != 2 || snapshot.Entries[0].HitCount != 3 || snapshot.Entries[1].HitCount != 3 {
+			t.Fatalf("session merged with an earlier one: %+v", snapshot.Entries)
+		}
+	}
+
+	// Profilers side by side, with the anchor cache switching between them
+	first, second := NewProfiler(), NewProfiler()
+	first.Begin()
+	stop := TimeBlock("only in first")
+	benchAnchor.Begin().End()
+	stop()
+	first.End()
+
+	second.Begin()
+	for range 5 {
+		benchAnchor.Begin().End()
+	}
+	second.End()
+	if ActiveProfiler() != second {
+		t.Error("Begin didn't make the profiler active")
+	}
+
+	firstSnapshot, secondSnapshot := first.Snapshot(), second.Snapshot()
+	if entry := findEntry(firstSnapshot, "only in first/bench"); entry == nil || entry.HitCount != 1 || len(firstSnapshot.Entries) != 2 {
+		t.Errorf("unexpected first profile: %+v", firstSnapshot.Entries)
+	}
+	if entry := findEntry(secondSnapshot, "bench"); entry == nil || entry.HitCount != 5 || len(secondSnapshot.Entries) != 1 {
+		t.Errorf("unexpected second profile: %+v", secondSnapshot.Entries)
+	}
+
+	var text bytes.Buffer
+	profiles := []NamedProfile{{Name: "first", Profile: firstSnapshot}, {Name: "second", Profile: secondSnapshot}}
+	if err := WriteProfiles(&text, profiles, FormatText); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(text.Bytes(), []byte("only in first")) || !bytes.Contains(text.Bytes(), []byte("second")) {
+		t.Errorf("comparison is missing rows or columns:\n%s", text.String())
+	}
+}
+
+// Run with -race: package level blocks from other goroutines must not touch
+// the default context's stack.
+func TestContextsOnOtherGoroutines(t *testing.T) {
+	enableTimingForTest(t)
+	BeginProfile()
+
+	stopOuter := TimeBlock("owner")
+	var wg sync.WaitGroup
+	for range 4 {
+		ctx := NewContext()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				stop := ctx.TimeBlock("goroutine")
+				benchAnchor.BeginIn(ctx, 0).End()
+				stop()
+			}
+		}()
+	}
+	for range 100 {
+		TimeBlock("owner child")()
+	}
+	wg.Wait()
+	stopOuter()
+
+	snapshot := EndProfile()
+	owner := findEntry(snapshot, "owner")
+	child := findEntry(snapshot, "owner/owner child")
+	if owner == nil || child == nil || owner.HitCount != 1 || child.HitCount != 100 {
+		t.Fatalf("the owner's blocks were disturbed: %+v", snapshot.Entries)
+	}
+	outer := findEntry(snapshot, "owner/goroutine")
+	inner := findEntry(snapshot, "owner/goroutine/bench")
+	if outer == nil || inner == nil || outer.HitCount != 400 || inner.HitCount != 400 {
+		t.Fatalf("goroutine blocks missing or not nested: %+v", snapshot.Entries)
+	}
+	// Nested blocks take their time out of the goroutine block's exclusive time
+	if outer.TSCExclusive+inner.TSCInclusive != outer.TSCInclusive {
+		t.Errorf("goroutine exclusive %d + anchor %d != goroutine inclusive %d", outer.TSCExclusive, inner.TSCInclusive, outer.TSCInclusive)
+	}
+}
@@ -0,0 +1,187 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/ryank157/perfAware/internal/shared"
+)
+
+// DefaultArenaSlabSize is the number of ArenaElements per slab when none is given.
+const DefaultArenaSlabSize = 64 * 1024
+
+// MaxArenaSourceSize is the largest input an ElementArena can parse, since
+// its spans are int32 offsets.
+const MaxArenaSourceSize = math.MaxInt32
+
+// Element allocation modes selectable from cmd/validate.
+const (
+	AllocGC    = "gc"    // new(Element) for every value, collected by the GC
+	AllocArena = "arena" // ArenaElements come from an ElementArena
+)
+
+// ArenaElement is the node of a tree parsed into an ElementArena, and the
+// parser's zero-copy tree: instead of Label and Value strings it keeps where
+// its text is in the source, so nothing is copied while parsing, lookups
+// compare bytes, a string is only made when Label or Value asks for one, and a
+// node is 32 bytes against Element's 48. Read the text through the arena that
+// holds the node.
+type ArenaElement struct {
+	FirstSubElement *ArenaElement
+	NextSibling     *ArenaElement
+
+	label span
+	value span
+}
+
+// span is where some text is: source[start:start+length] of the arena, or,
+// for strings whose escapes were decoded in strict mode, at ^start in its
+// decoded buffer.
+type span struct {
+	start  int32
+	length int32
+}
+
+// ElementArena hands out ArenaElements from slabs of SlabSize
+// (DefaultArenaSlabSize for the zero value), so parsing costs one allocation
+// per slab instead of one per value, and Reset lets the next parse reuse the
+// same slabs without involving the GC at all.
+//
+// The arena also keeps the source the last ParseJSONArena read, since its
+// nodes only point into it.
+type ElementArena struct {
+	SlabSize int
+
+	slabs [][]ArenaElement
+	slab  int // slab being handed out from
+	used  int // elements handed out from it
+
+	source  []byte
+	decoded []byte // decoded text of escaped strict mode strings
+}
+
+// NewElementArena returns an empty arena; slabSize <= 0 selects DefaultArenaSlabSize.
+func NewElementArena(slabSize int) *ElementArena {
+	if slabSize <= 0 {
+		slabSize = DefaultArenaSlabSize
+	}
+	return &ElementArena{SlabSize: slabSize}
+}
+
+// New returns a zeroed ArenaElement that stays valid until the next Reset.
+func (a *ElementArena) New() *ArenaElement {
+	if a.slab >= len(a.slabs) || a.used == len(a.slabs[a.slab]) {
+		if a.slab < len(a.slabs) {
+			a.slab++
+		}
+		if a.slab == len(a.slabs) {
+			if a.SlabSize <= 0 {
+				a.SlabSize = DefaultArenaSlabSize
+			}
+			a.slabs = append(a.slabs, make([]ArenaElement, a.SlabSize))
+		}
+		a.used = 0
+	}
+
+	element := &a.slabs[a.slab][a.used]
+	a.used++
+	*element = ArenaElement{} // may be left over from before a Reset
+	return element
+}
+
+// Reset makes every slab available again. Elements handed out before must
+// not be used afterwards.
+func (a *ElementArena) Reset() {
+	a.slab = 0
+	a.used = 0
+	a.source = nil
+	a.decoded = a.decoded[:0]
+}
+
+// Len is the number of ArenaElements handed out since the last Reset.
+func (a *ElementArena) Len() int {
+	if a.slab >= len(a.slabs) {
+		return 0
+	}
+	return a.slab*a.SlabSize + a.used
+}
+
+// Cap is the number of ArenaElements the allocated slabs hold.
+func (a *ElementArena) Cap() int {
+	return len(a.slabs) * a.SlabSize
+}
+
+// Label returns the label of element, copied into a new string.
+func (a *ElementArena) Label(element *ArenaElement) string {
+	return string(a.text(element.label))
+}
+
+// Value is Label for the value.
+func (a *ElementArena) Value(element *ArenaElement) string {
+	return string(a.text(element.value))
+}
+
+// text returns the bytes of s without copying. They belong to the source or
+// the decoded buffer, so only the package reads them.
+func (a *ElementArena) text(s span) []byte {
+	if s.start < 0 {
+		start := ^s.start
+		return a.decoded[start : start+s.length]
+	}
+	return a.source[s.start : s.start+s.length]
+}
+
+// newElement is the parser's node constructor for arena trees.
+func (a *ElementArena) newElement(p *Parser, labelToken Token, valueToken Token, subElement *ArenaElement) *ArenaElement {
+	result := a.New()
+	result.label = a.span(p, labelToken)
+	result.value = a.span(p, valueToken)
+	result.FirstSubElement = subElement
+	return result
+}
+
+// span returns where token's text is. The zero Token (no label) is an empty span.
+func (a *ElementArena) span(p *Parser, token Token) span {
+	if token.Type == TokenEndOfStream || token.Length <= 0 {
+		return span{}
+	}
+	if p.Strict && token.Type == TokenStringLiteral && bytes.IndexByte(p.TokenBytes(token), '\\') >= 0 {
+		text := p.tokenString(token)
+		start := len(a.decoded)
+		a.decoded = append(a.decoded, text...)
+		return span{start: ^int32(start), length: int32(len(text))}
+	}
+	return span{start: int32(token.Start), length: int32(token.Length)}
+}
+
+// LookupElement is the package level LookupElement for an arena tree.
+func (a *ElementArena) LookupElement(object *ArenaElement, elementName string) *ArenaElement {
+	if object != nil {
+		for search := object.FirstSubElement; search != nil; search = search.NextSibling {
+			if string(a.text(search.label)) == elementName {
+				return search
+			}
+		}
+	}
+	return nil
+}
+
+// ConvertElementToFloat64 is the package level ConvertElementToFloat64 for an
+// arena tree. It converts straight from the source, without copying.
+func (a *ElementArena) ConvertElementToFloat64(object *ArenaElement, elementName string, convert FloatConverter) (float64, error) {
+	element := a.LookupElement(object, elementName)
+	if element == nil {
+		return 0, fmt.Errorf("%w: missing field %q", shared.ErrMalformedInput, elementName)
+	}
+
+	result, err := convert(a.text(element.value))
+	if err != nil {
+		return 0, fmt.Errorf("field %q: %w", elementName, err)
+	}
+	return result, nil
+}
+
+func (e *ArenaElement) firstSubElement() *ArenaElement    { return e.FirstSubElement }
+func (e *ArenaElement) nextSibling() *ArenaElement        { return e.NextSibling }
+func (e *ArenaElement) setNextSibling(next *ArenaElement) { e.NextSibling = next }
@@ -0,0 +1,100 @@
+package validator
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestArenaParseMatchesNew(t *testing.T) {
+	input := []byte(`{"pairs":[{"X0":1.5,"Y0":-2},{"X0":3,"Y0":4}],"name":"a\"b","flag":true}`)
+	arena := NewElementArena(3) // small, so the tree spans several slabs
+
+	for _, strict := range []bool{false, true} {
+		want := (&Parser{Strict: strict}).ParseJSON(input)
+
+		for repetition := range 3 {
+			parser := Parser{Strict: strict}
+			got := parser.ParseJSONArena(input, arena)
+			if parser.HadError {
+				t.Fatalf("parse error: %v", parser.Errors)
+			}
+			compareElements(t, arena, got, want)
+
+			if arena.Len() == 0 || arena.Cap() < arena.Len() {
+				t.Errorf("repetition %d: Len %d, Cap %d", repetition, arena.Len(), arena.Cap())
+			}
+			capBefore := arena.Cap()
+			arena.Reset()
+			if arena.Len() != 0 || arena.Cap() != capBefore {
+				t.Errorf("after Reset: Len %d, Cap %d, want 0, %d", arena.Len(), arena.Cap(), capBefore)
+			}
+		}
+	}
+}
+
+func compareElements(t *testing.T, arena *ElementArena, got *ArenaElement, want *Element) {
+	t.Helper()
+	for ; got != nil && want != nil; got, want = got.NextSibling, want.NextSibling {
+		if arena.Label(got) != want.Label || arena.Value(got) != want.Value {
+			t.Errorf("got %q: %q, want %q: %q", arena.Label(got), arena.Value(got), want.Label, want.Value)
+		}
+		compareElements(t, arena, got.FirstSubElement, want.FirstSubElement)
+	}
+	if got != nil || want != nil {
+		t.Errorf("trees have a different shape")
+	}
+}
+
+func TestArenaElementLookups(t *testing.T) {
+	if size := unsafe.Sizeof(Element{}); size != 2*unsafe.Sizeof("")+2*unsafe.Sizeof(&Element{}) {
+		t.Errorf("Element is %d bytes, want two strings and two pointers", size)
+	}
+	if unsafe.Sizeof(ArenaElement{}) >= unsafe.Sizeof(Element{}) {
+		t.Errorf("ArenaElement is %d bytes, not smaller than Element", unsafe.Sizeof(ArenaElement{}))
+	}
+
+	input := []byte(`{"pair":{"X0":1.5,"Y0":-2,"X1":3,"Y1":4}}`)
+	arena := NewElementArena(0)
+	var parser Parser
+	root := parser.ParseJSONArena(input, arena)
+	pair := arena.LookupElement(root, "pair")
+	if parser.HadError || pair == nil {
+		t.Fatalf("parse failed: %v", parser.Errors)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, name := range []string{"X0", "Y0", "X1", "Y1"} {
+			if _, err := arena.ConvertElementToFloat64(pair, name, ParseFloatFast); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("lookups and conversions allocated %v times, want 0", allocs)
+	}
+
+	y0 := arena.LookupElement(pair, "Y0")
+	if arena.Label(y0) != "Y0" || arena.Value(y0) != "-2" {
+		t.Errorf("got %q: %q, want \"Y0\": \"-2\"", arena.Label(y0), arena.Value(y0))
+	}
+}
+
+func TestElementLookupsDontAllocate(t *testing.T) {
+	var parser Parser
+	root := parser.ParseJSON([]byte(`{"pair":{"X0":1.5,"Y0":-2,"X1":3,"Y1":4}}`))
+	pair := LookupElement(root, "pair")
+	if parser.HadError || pair == nil {
+		t.Fatalf("parse failed: %v", parser.Errors)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, name := range []string{"X0", "Y0", "X1", "Y1"} {
+			if _, err := ConvertElementToFloat64(pair, name, ParseFloatFast); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("lookups and conversions allocated %v times, want 0", allocs)
+	}
+}
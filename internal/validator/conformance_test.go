@@ -0,0 +1,123 @@
+package validator
+
+import "testing"
+
+// Accept/reject cases in the style of JSONTestSuite: y_ must parse in strict
+// mode, n_ must not.
+var conformanceCases = []struct {
+	name  string
+	input string
+}{
+	{"y_array_empty", `[]`},
+	{"y_object_empty", `{}`},
+	{"y_array_nested", `[[[]]]`},
+	{"y_array_literals", `[null, true, false]`},
+	{"y_object_nested", `{"a":[{"b":{}}]}`},
+	{"y_object_empty_key", `{"":0}`},
+	{"y_object_duplicated_key", `{"a":1,"a":2}`},
+	{"y_structure_whitespace", " \t\r\n[1]\n "},
+	{"y_structure_lonely_string", `"scalar"`},
+	{"y_structure_lonely_int", `2`},
+	{"y_number_minus_zero", `[-0]`},
+	{"y_number_negative", `[-1]`},
+	{"y_number_real", `[0.5]`},
+	{"y_number_exponent", `[1E22]`},
+	{"y_number_negative_exponent", `[1e-2]`},
+	{"y_number_plus_exponent", `[1.5e+3]`},
+	{"y_string_escapes", `["\"\\\/\b\f\n\r\t"]`},
+	{"y_string_unicode_escapes", `["\u0060\u012a\u12AB"]`},
+	{"y_string_surrogate_pair", `["\uD801\udc37"]`},
+	{"y_string_lone_surrogate", `["\uD800"]`},
+	{"y_string_utf8", `["€𝄞"]`},
+
+	{"n_structure_empty", ``},
+	{"n_structure_whitespace_only", ` `},
+	{"n_structure_trailing_data", `[1] x`},
+	{"n_structure_two_values", `[1][2]`},
+	{"n_structure_unclosed_array", `[1`},
+	{"n_structure_unclosed_object", `{"a":1`},
+	{"n_structure_open_array", `[`},
+	{"n_structure_semicolon", `[1;2]`},
+	{"n_structure_UTF8_BOM", "\xef\xbb\xbf[]"},
+	{"n_array_trailing_comma", `[1,]`},
+	{"n_array_leading_comma", `[,1]`},
+	{"n_array_lone_comma", `[,]`},
+	{"n_array_missing_comma", `[1 2]`},
+	{"n_object_trailing_comma", `{"a":1,}`},
+	{"n_object_missing_colon", `{"a" 1}`},
+	{"n_object_missing_value", `{"a":}`},
+	{"n_object_key_only", `{"a"}`},
+	{"n_object_number_key", `{1:1}`},
+	{"n_object_single_quotes", `{'a':1}`},
+	{"n_number_leading_zero", `[01]`},
+	{"n_number_minus_only", `[-]`},
+	{"n_number_starting_with_dot", `[.5]`},
+	{"n_number_ending_with_dot", `[1.]`},
+	{"n_number_empty_exponent", `[1e]`},
+	{"n_number_plus", `[+1]`},
+	{"n_number_hex", `[0x1]`},
+	{"n_number_infinity", `[Infinity]`},
+	{"n_number_nan", `[NaN]`},
+	{"n_incomplete_true", `[tru]`},
+	{"n_true_with_suffix", `[truex]`},
+	{"n_string_invalid_escape", `["a\x"]`},
+	{"n_string_invalid_unicode_escape", `["\u00G0"]`},
+	{"n_string_short_unicode_escape", `["\u12"]`},
+	{"n_string_unescaped_tab", "[\"a\tb\"]"},
+	{"n_string_unescaped_newline", "[\"a\nb\"]"},
+	{"n_string_unterminated", `["abc]`},
+	{"n_string_escaped_quote_unterminated", `["abc\"]`},
+	{"n_string_invalid_utf8", "[\"\xff\"]"},
+	{"n_string_overlong_utf8", "[\"\xc0\xaf\"]"},
+}
+
+func TestStrictConformance(t *testing.T) {
+	for _, tc := range conformanceCases {
+		parser := Parser{Strict: true}
+		parser.ParseJSON([]byte(tc.input))
+		accepted := !parser.HadError
+
+		if want := tc.name[0] == 'y'; accepted != want {
+			t.Errorf("%s: %q accepted = %v, want %v", tc.name, tc.input, accepted, want)
+		}
+	}
+}
+
+func TestStrictStringDecoding(t *testing.T) {
+	parser := Parser{Strict: true}
+	root := parser.ParseJSON([]byte(`{"tab\tkey": "a\"b\\c\/\n", "pair": "\uD834\uDD1E", "lone": "x\uDC00y", "X0": 1}`))
+	if parser.HadError {
+		t.Fatal("unexpected parse error")
+	}
+
+	want := map[string]string{
+		"tab\tkey": "a\"b\\c/\n",
+		"pair":     "𝄞",
+		"lone":     "x\uFFFDy",
+		"X0":       "1",
+	}
+	for label, value := range want {
+		element := LookupElement(root, label)
+		if element == nil {
+			t.Errorf("no element labelled %q", label)
+		} else if element.Value != value {
+			t.Errorf("%q = %q, want %q", label, element.Value, value)
+		}
+	}
+}
+
+func TestParserPosition(t *testing.T) {
+	parser := Parser{Source: []byte("{\n  \"a\": 1,\n  \"b\" 2\n}")}
+	for _, tc := range []struct{ offset, line, column int }{
+		{0, 1, 1},
+		{1, 1, 2},
+		{2, 2, 1},
+		{4, 2, 3},
+		{18, 3, 7},
+	} {
+		line, column := parser.Position(tc.offset)
+		if line != tc.line || column != tc.column {
+			t.Errorf("Position(%d) = %d:%d, want %d:%d", tc.offset, line, column, tc.line, tc.column)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"sync"
+
+	"github.com/ryank157/perfAware/internal/shared"
+)
+
+// Float converter names selectable from cmd/validate.
+const (
+	FloatFast    = "fast"    // ParseFloatFast: Clinger's fast path, then Eisel-Lemire, then strconv
+	FloatStrconv = "strconv" // strconv.ParseFloat
+	FloatNaive   = "naive"   // ConvertJSONNumber, the original digit loop; not correctly rounded
+)
+
+// FloatConverter turns the text of a JSON number token into a float64.
+// Every converter accepts exactly the JSON number grammar and returns an error
+// wrapping shared.ErrMalformedInput for anything else. Magnitudes too large
+// for a float64 become ±Inf and too small ones subnormals or zero; neither is
+// an error. source may share memory with the input or an Element's Value
+// string, so a converter must never modify it.
+type FloatConverter func(source []byte) (float64, error)
+
+// CheckJSONNumber returns an error unless source is a JSON number:
+//
+//	-? (0 | [1-9][0-9]*) (\.[0-9]+)? ([eE][+-]?[0-9]+)?
+func CheckJSONNumber(source []byte) error {
+	at := 0
+	fail := func(reason string) error {
+		return fmt.Errorf("%w: invalid number %q: %s", shared.ErrMalformedInput, source, reason)
+	}
+	digits := func() int {
+		start := at
+		for at < len(source) && isDigit(source[at]) {
+			at++
+		}
+		return at - start
+	}
+
+	if at < len(source) && source[at] == '-' {
+		at++
+	}
+	if at < len(source) && source[at] == '0' {
+		at++
+		if at < len(source) && isDigit(source[at]) {
+			return fail("leading zero")
+		}
+	} else if digits() == 0 {
+		return fail("expected a digit")
+	}
+
+	if at < len(source) && source[at] == '.' {
+		at++
+		if digits() == 0 {
+			return fail("expected a digit after the decimal point")
+		}
+	}
+
+	if at < len(source) && (source[at] == 'e' || source[at] == 'E') {
+		at++
+		if at < len(source) && (source[at] == '+' || source[at] == '-') {
+			at++
+		}
+		if digits() == 0 {
+			return fail("expected a digit in the exponent")
+		}
+	}
+
+	if at != len(source) {
+		return fail(fmt.Sprintf("unexpected %q", source[at]))
+	}
+	return nil
+}
+
+// FloatConverterByName returns the converter for name; "" selects FloatFast.
+func FloatConverterByName(name string) (FloatConverter, error) {
+	switch name {
+	case FloatFast, "":
+		return ParseFloatFast, nil
+	case FloatStrconv:
+		return parseFloatStrconv, nil
+	case FloatNaive:
+		return ConvertJSONNumber, nil
+	default:
+		return nil, fmt.Errorf("unknown float converter %q, must be '%s', '%s' or '%s'", name, FloatFast, FloatStrconv, FloatNaive)
+	}
+}
+
+func parseFloatStrconv(source []byte) (float64, error) {
+	if err := CheckJSONNumber(source); err != nil {
+		return 0, err
+	}
+	return strconvFloat(source)
+}
+
+// strconvFloat is parseFloatStrconv for source already known to be a JSON number.
+func strconvFloat(source []byte) (float64, error) {
+	result, err := strconv.ParseFloat(string(source), 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("%w: %w", shared.ErrMalformedInput, err)
+	}
+	return result, nil
+}
+
+// ParseFloatFast converts a JSON number correctly rounded, without allocating
+// for the common case. The decimal mantissa is gathered into a uint64 and
+//
+//  1. if it and the power of ten are both exact in a float64, one multiply or
+//     divide gives the correctly rounded result (Clinger's fast path);
+//  2. otherwise Eisel-Lemire multiplies by a 128-bit power of ten, which
+//     settles all but the rare inputs too close to a rounding boundary;
+//  3. those, and mantissas longer than 19 digits, go to strconv.ParseFloat.
+func ParseFloatFast(source []byte) (float64, error) {
+	if err := CheckJSONNumber(source); err != nil {
+		return 0, err
+	}
+
+	at := 0
+	negative := false
+	if at < len(source) && source[at] == '-' {
+		negative = true
+		at++
+	}
+
+	mantissa := uint64(0)
+	digitCount := 0 // significant digits in mantissa, leading zeros excluded
+	exp10 := 0
+	truncated := false
+
+	addDigit := func(digit byte) {
+		if digitCount < 19 {
+			mantissa = 10*mantissa + uint64(digit)
+			if mantissa != 0 {
+				digitCount++
+			}
+		} else {
+			truncated = truncated || digit != 0
+			exp10++
+		}
+	}
+
+	for at < len(source) && isDigit(source[at]) {
+		addDigit(source[at] - '0')
+		at++
+	}
+	if at < len(source) && source[at] == '.' {
+		at++
+		for at < len(source) && isDigit(source[at]) {
+			addDigit(source[at] - '0')
+			exp10--
+			at++
+		}
+	}
+	if at < len(source) && (source[at] == 'e' || source[at] == 'E') {
+		at++
+		exponentSign := 1
+		if at < len(source) && (source[at] == '+' || source[at] == '-') {
+			if source[at] == '-' {
+				exponentSign = -1
+			}
+			at++
+		}
+		exponent := 0
+		for at < len(source) && isDigit(source[at]) {
+			if exponent < 100_000 { // far past any float64, and can't overflow
+				exponent = 10*exponent + int(source[at]-'0')
+			}
+			at++
+		}
+		exp10 += exponentSign * exponent
+	}
+
+	if !truncated {
+		if result, ok := clingerFastPath(mantissa, exp10, negative); ok {
+			return result, nil
+		}
+		if result, ok := eiselLemire(mantissa, exp10, negative); ok {
+			return result, nil
+		}
+	}
+	return strconvFloat(source)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// exactPowersOfTen are the powers of ten a float64 holds exactly.
+var exactPowersOfTen = [...]float64{
+	1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11,
+	1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22,
+}
+
+func clingerFastPath(mantissa uint64, exp10 int, negative bool) (float64, bool) {
+	if mantissa>>53 != 0 || exp10 < -22 || exp10 > 22 {
+		return 0, false
+	}
+	result := float64(mantissa)
+	if exp10 < 0 {
+		result /= exactPowersOfTen[-exp10]
+	} else {
+		result *= exactPowersOfTen[exp10]
+	}
+	if negative {
+		result = -result
+	}
+	return result, true
+}
+
+const (
+	minPowerOfTen = -348
+	maxPowerOfTen = 347
+)
+
+// powersOfTen holds 10^q for q in [minPowerOfTen, maxPowerOfTen] as a 128-bit
+// mantissa {lo, hi} with the top bit set, rounded down. It is built with
+// math/big the first time the fast converter needs it.
+var powersOfTen = sync.OnceValue(func() *[maxPowerOfTen - minPowerOfTen + 1][2]uint64 {
+	var table [maxPowerOfTen - minPowerOfTen + 1][2]uint64
+	ten := big.NewInt(10)
+	mask := new(big.Int).SetUint64(math.MaxUint64)
+	for q := minPowerOfTen; q <= maxPowerOfTen; q++ {
+		power := new(big.Int).Exp(ten, big.NewInt(int64(abs(q))), nil)
+		mantissa := new(big.Int)
+		if q >= 0 {
+			// Keep the top 128 bits of 10^q
+			if shift := power.BitLen() - 128; shift > 0 {
+				mantissa.Rsh(power, uint(shift))
+			} else {
+				mantissa.Lsh(power, uint(-shift))
+			}
+		} else {
+			// 2^(bits+127) / 10^-q lies strictly between 2^127 and 2^128
+			numerator := new(big.Int).Lsh(big.NewInt(1), uint(power.BitLen()+127))
+			mantissa.Quo(numerator, power)
+		}
+		entry := &table[q-minPowerOfTen]
+		entry[0] = new(big.Int).And(mantissa, mask).Uint64()
+		entry[1] = new(big.Int).Rsh(mantissa, 64).Uint64()
+	}
+	return &table
+})
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// eiselLemire computes mantissa * 10^exp10 correctly rounded, or reports
+// false when the 128-bit product can't tell which way to round. See Daniel
+// Lemire, "Number Parsing at a Gigabyte per Second" (2021).
+func eiselLemire(mantissa uint64, exp10 int, negative bool) (float64, bool) {
+	if mantissa == 0 {
+		if negative {
+			return math.Copysign(0, -1), true
+		}
+		return 0, true
+	}
+	if exp10 < minPowerOfTen || exp10 > maxPowerOfTen {
+		return 0, false
+	}
+	power := &powersOfTen()[exp10-minPowerOfTen]
+
+	// Normalize, and estimate the biased binary exponent: 217706/2^16 ~ log2(10)
+	const exponentBias = 1023
+	leadingZeros := bits.LeadingZeros64(mantissa)
+	mantissa <<= uint(leadingZeros)
+	exp2 := uint64(217706*exp10>>16+64+exponentBias) - uint64(leadingZeros)
+
+	hi, lo := bits.Mul64(mantissa, power[1])
+
+	// The low bits are all ones, so the truncated half of the power of ten
+	// could carry into them: include it
+	if hi&0x1FF == 0x1FF && lo+mantissa < mantissa {
+		loHi, loLo := bits.Mul64(mantissa, power[0])
+		mergedHi, mergedLo := hi, lo+loHi
+		if mergedLo < lo {
+			mergedHi++
+		}
+		if mergedHi&0x1FF == 0x1FF && mergedLo+1 == 0 && loLo+mantissa < mantissa {
+			return 0, false
+		}
+		hi, lo = mergedHi, mergedLo
+	}
+
+	// Keep 54 bits, one more than a float64 mantissa for rounding
+	msb := hi >> 63
+	result := hi >> (msb + 9)
+	exp2 -= 1 ^ msb
+
+	// Exactly halfway between two floats: the truncated bits would decide
+	if lo == 0 && hi&0x1FF == 0 && result&3 == 1 {
+		return 0, false
+	}
+
+	// Round to 53 bits, half to even
+	result += result & 1
+	result >>= 1
+	if result>>53 > 0 {
+		result >>= 1
+		exp2++
+	}
+
+	// Subnormals, infinities and NaNs are left to the fallback
+	if exp2-1 >= 0x7FF-1 {
+		return 0, false
+	}
+	resultBits := exp2<<52 | result&(1<<52-1)
+	if negative {
+		resultBits |= 1 << 63
+	}
+	return math.Float64frombits(resultBits), true
+}
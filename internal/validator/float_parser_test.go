@@ -0,0 +1,89 @@
+package validator
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/ryank157/perfAware/internal/shared"
+)
+
+func TestParseFloatFastMatchesStrconv(t *testing.T) {
+	inputs := []string{
+		"0", "-0", "1", "-1", "0.1", "0.3", "123.456", "-179.99999999999997",
+		"89.123456789012345", "1e22", "1e23", "9007199254740993",
+		"1.7976931348623157e308", "2.2250738585072014e-308", "4.9e-324",
+		"1e400", "-1e400", "1e-400", "0.000000000000000000000000000001",
+		"12345678901234567890123", "1.00000000000000011102230246251565404236316680908203125",
+		"1.00000000000000011102230246251565404236316680908203124",
+		"1.00000000000000011102230246251565404236316680908203126",
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for range 20000 {
+		f := math.Float64frombits(rng.Uint64())
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+		inputs = append(inputs, strconv.FormatFloat(f, 'g', -1, 64))
+		inputs = append(inputs, strconv.FormatFloat(f, 'e', rng.Intn(20), 64))
+		inputs = append(inputs, strconv.FormatFloat((rng.Float64()-0.5)*360, 'f', -1, 64))
+	}
+
+	for _, input := range inputs {
+		want, _ := strconv.ParseFloat(input, 64)
+		got, err := ParseFloatFast([]byte(input))
+		if err != nil {
+			t.Errorf("ParseFloatFast(%q): %v", input, err)
+		} else if math.Float64bits(got) != math.Float64bits(want) {
+			t.Errorf("ParseFloatFast(%q) = %v, strconv says %v", input, got, want)
+		}
+	}
+}
+
+func TestFloatConvertersEdgeCases(t *testing.T) {
+	valid := []struct {
+		input string
+		want  float64
+	}{
+		{"1.5e-3", 1.5e-3},
+		{"-2.5E+2", -250},
+		{"-0", math.Copysign(0, -1)},
+		{"-0.0e5", math.Copysign(0, -1)},
+		{"0e99999999", 0},
+		{"1e400", math.Inf(1)},
+		{"-1e400", math.Inf(-1)},
+		{"1e-400", 0},
+		{"1e-310", 1e-310},
+		{"4.9e-324", 5e-324},
+	}
+	invalid := []string{"", "-", "+1", "01", "-01", "1.", ".5", "1e", "1e+", "0x10", "1.5.2", "Infinity", "NaN", "1 "}
+
+	for _, name := range []string{FloatFast, FloatStrconv, FloatNaive} {
+		convert, err := FloatConverterByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range valid {
+			got, err := convert([]byte(tc.input))
+			if err != nil {
+				t.Errorf("%s(%q): %v", name, tc.input, err)
+				continue
+			}
+			// The naive converter isn't correctly rounded, so only ask for the right ballpark
+			near := got == tc.want || math.Abs(got-tc.want) <= 1e-12*math.Abs(tc.want)
+			if !near || math.Signbit(got) != math.Signbit(tc.want) {
+				t.Errorf("%s(%q) = %v, want %v", name, tc.input, got, tc.want)
+			}
+		}
+
+		for _, input := range invalid {
+			if got, err := convert([]byte(input)); !errors.Is(err, shared.ErrMalformedInput) {
+				t.Errorf("%s(%q) = %v, %v, want ErrMalformedInput", name, input, got, err)
+			}
+		}
+	}
+}
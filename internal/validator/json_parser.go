@@ -2,7 +2,7 @@ package validator
 
 import (
 	"fmt"
-	"os"
+	"unsafe"
 
 	"github.com/ryank157/perfAware/internal/shared"
 	"github.com/ryank157/perfAware/internal/timing"
@@ -32,6 +32,8 @@ type Token struct {
 	Length int
 }
 
+// Element is one value of the parsed tree, with its text copied into Label
+// and Value. Trees parsed into an ElementArena use ArenaElement instead.
 type Element struct {
 	Label           string
 	Value           string
@@ -39,10 +41,38 @@ type Element struct {
 	NextSibling     *Element
 }
 
+// labelIs compares the label with name.
+func (e *Element) labelIs(name string) bool {
+	return e.Label == name
+}
+
+// valueBytes returns the value without copying, for a FloatConverter. The
+// bytes are the Value string's, so they are only ever read.
+func (e *Element) valueBytes() []byte {
+	return unsafe.Slice(unsafe.StringData(e.Value), len(e.Value))
+}
+
+func (e *Element) firstSubElement() *Element    { return e.FirstSubElement }
+func (e *Element) nextSibling() *Element        { return e.NextSibling }
+func (e *Element) setNextSibling(next *Element) { e.NextSibling = next }
+
+// treeNode is the node type the tree parser builds: *Element, or *ArenaElement
+// for ParseJSONArena.
+type treeNode[N any] interface {
+	*N
+	firstSubElement() *N
+	nextSibling() *N
+	setNextSibling(next *N)
+}
+
 type Parser struct {
 	Source   []byte
 	At       int
 	HadError bool
+	Errors   ParseErrors // what went wrong when HadError is set
+	Strict   bool        // enforce RFC 8259 exactly and decode string escapes, see strict.go
+
+	tokenProblem string // why the last TokenError was rejected, if the tokenizer knows
 }
 
 func IsJSONDigit(source []byte, at int) bool {
@@ -67,9 +97,30 @@ func (p *Parser) IsParsing() bool {
 	return !p.HadError && IsInBounds(p.Source, p.At)
 }
 
-func (p *Parser) Error(token Token, message string) {
+// Error records a ParseError at token, saying what was expected there, and
+// stops parsing. Only the first error is recorded: the parser doesn't try to
+// recover, so anything after it would just be fallout.
+func (p *Parser) Error(token Token, expected string) {
+	if p.HadError {
+		return
+	}
 	p.HadError = true
-	fmt.Fprintf(os.Stderr, "ERROR: \"%s\" - %s\n", p.ExtractTokenValue(token), message)
+	line, column := p.Position(token.Start)
+	p.Errors = append(p.Errors, ParseError{
+		Offset:   token.Start,
+		Line:     line,
+		Column:   column,
+		Token:    p.ExtractTokenValue(token),
+		Expected: expected,
+		Found:    p.describeToken(token),
+	})
+}
+
+// errorAtEnd records that the input ended inside the list opened by open.
+func (p *Parser) errorAtEnd(open Token, closer string) {
+	line, column := p.Position(open.Start)
+	end := Token{Type: TokenEndOfStream, Start: len(p.Source)}
+	p.Error(end, fmt.Sprintf("%s to close the %s at line %d, column %d", closer, tokenTypeNames[open.Type], line, column))
 }
 
 func (p *Parser) ParseKeyword(keyword string, result *Token) {
@@ -99,15 +150,18 @@ func keywordToTokenType(keyword string) int {
 }
 
 func (p *Parser) GetJSONToken() Token {
-	var result Token
 	source := p.Source
 	at := p.At
+	p.tokenProblem = ""
 
 	for p.IsParsing() && IsJSONWhitespace(source, at) {
 		at++
 		p.At = at
 	}
 
+	// At the end of the input, Start still says where that is for errors
+	result := Token{Type: TokenEndOfStream, Start: at}
+
 	if p.IsParsing() {
 		result.Type = TokenError
 		result.Start = at
@@ -133,20 +187,30 @@ func (p *Parser) GetJSONToken() Token {
 			result.Type = TokenColon
 			at++
 		case ';':
-			result.Type = TokenSemiColon
-			at++
+			// Not JSON at all, but the loose tokenizer has always let it through
+			if !p.Strict {
+				result.Type = TokenSemiColon
+				at++
+			}
 		case 'f':
 			p.ParseKeyword("false", &result)
+			at = p.At
 		case 't':
 			p.ParseKeyword("true", &result)
+			at = p.At
 		case 'n':
 			p.ParseKeyword("null", &result)
+			at = p.At
 		case '"':
+			if p.Strict {
+				result, at = p.strictStringToken(at)
+				break
+			}
 			result.Type = TokenStringLiteral
 			start := at + 1
 			at++ //skip opening quote
 
-			for p.IsParsing() && source[at] != '"' {
+			for IsInBounds(source, at) && source[at] != '"' {
 				if at+1 < len(source) && source[at] == '\\' && source[at+1] == '"' {
 					at++ //skip escaped quote
 				}
@@ -154,9 +218,10 @@ func (p *Parser) GetJSONToken() Token {
 			}
 			result.Start = start
 			result.Length = at - start
-			if p.IsParsing() && source[at] == '"' {
+			if IsInBounds(source, at) && source[at] == '"' {
 				at++ //skip closing quote
 			}
+
 		case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 			start := at
 			result.Type = TokenNumber
@@ -166,29 +231,35 @@ func (p *Parser) GetJSONToken() Token {
 			}
 
 			//parse before decimal
-			for p.IsParsing() && IsJSONDigit(source, at) {
+			for IsJSONDigit(source, at) {
 				at++
 			}
 
 			//Handle decimal point and digits after
-			if p.IsParsing() && source[at] == '.' {
+			if IsInBounds(source, at) && source[at] == '.' {
 				at++
-				for p.IsParsing() && IsJSONDigit(source, at) {
+				for IsJSONDigit(source, at) {
 					at++
 				}
 			}
 
-			if p.IsParsing() && (source[at] == 'e' || source[at] == 'E') {
+			if IsInBounds(source, at) && (source[at] == 'e' || source[at] == 'E') {
 				at++
-				if p.IsParsing() && (source[at] == '+' || source[at] == '-') {
+				if IsInBounds(source, at) && (source[at] == '+' || source[at] == '-') {
 					at++
 				}
-				for p.IsParsing() && IsJSONDigit(source, at) {
+				for IsJSONDigit(source, at) {
 					at++
 				}
 			}
 			result.Start = start
 			result.Length = at - start
+			if p.Strict {
+				if err := CheckJSONNumber(source[start:at]); err != nil {
+					result.Type = TokenError
+					p.tokenProblem = err.Error()
+				}
+			}
 		default:
 			// Leave Type as TokenError, Start as at, Length as 1
 		}
@@ -207,65 +278,82 @@ func (p *Parser) ExtractTokenValue(token Token) string {
 	return string(p.Source[token.Start : token.Start+token.Length])
 }
 
-func (p *Parser) _ParseJSONList(startingToken Token, endType int, hasLabels bool) *Element {
+// parseJSONList parses the values of the list opened by startingToken, up to
+// its endType token, into nodes made by newElement.
+func parseJSONList[N any, P treeNode[N]](p *Parser, newElement func(labelToken Token, valueToken Token, subElement P) P, startingToken Token, endType int, hasLabels bool) P {
 	// defer timing.TimeFunction()()
-	var firstElement *Element
-	var lastElement *Element
+	var firstElement P
+	var lastElement P
+	closed := false
 
 	for p.IsParsing() {
-		var label string
+		var labelToken Token
+		labeled := false
 		valueToken := p.GetJSONToken()
 
 		if hasLabels {
 			if valueToken.Type == TokenStringLiteral {
-				label = p.ExtractTokenValue(valueToken)
+				labelToken = valueToken
+				labeled = true
 				colon := p.GetJSONToken()
 				if colon.Type == TokenColon {
 					valueToken = p.GetJSONToken()
 				} else {
-					p.Error(colon, "Expected colon after field name")
+					p.Error(colon, "':' after the field name")
 				}
 			} else if valueToken.Type != endType {
-				p.Error(valueToken, "Unexpected token in JSON")
+				p.Error(valueToken, "a field name or '}'")
 			}
 		}
 
-		element := p._ParseJSONElement(label, valueToken)
+		element := parseJSONElement(p, newElement, labelToken, valueToken)
 
 		if element != nil {
 			if lastElement == nil {
 				firstElement = element
 				lastElement = element
 			} else {
-				lastElement.NextSibling = element
+				lastElement.setNextSibling(element)
 				lastElement = element
 			}
 		} else if valueToken.Type == endType {
+			if p.Strict && labeled {
+				p.Error(valueToken, "a value after the field name")
+			} else if p.Strict && lastElement != nil {
+				p.Error(valueToken, "a value after ','")
+			}
+			closed = true
 			break
 		} else {
-			p.Error(valueToken, "Unexpected token in JSON")
+			p.Error(valueToken, "a value")
 		}
 
 		comma := p.GetJSONToken()
 		if comma.Type == endType {
+			closed = true
 			break
 		} else if comma.Type != TokenComma {
-			p.Error(comma, "Unexpected token in JSON. Expected ,")
+			p.Error(comma, closerFor(endType))
 		}
 
 	}
+	if !closed {
+		p.errorAtEnd(startingToken, tokenTypeNames[endType])
+	}
 	return firstElement
 }
 
-func (p *Parser) _ParseJSONElement(label string, valueToken Token) *Element {
+// parseJSONElement parses the value starting with valueToken. labelToken is
+// its field name in an object, the zero Token otherwise.
+func parseJSONElement[N any, P treeNode[N]](p *Parser, newElement func(labelToken Token, valueToken Token, subElement P) P, labelToken Token, valueToken Token) P {
 	valid := true
-	var subElement *Element
+	var subElement P
 	valueType := valueToken.Type
 
 	if valueType == TokenOpenBracket {
-		subElement = p._ParseJSONList(valueToken, TokenCloseBracket, false)
+		subElement = parseJSONList(p, newElement, valueToken, TokenCloseBracket, false)
 	} else if valueType == TokenOpenBrace {
-		subElement = p._ParseJSONList(valueToken, TokenCloseBrace, true)
+		subElement = parseJSONList(p, newElement, valueToken, TokenCloseBrace, true)
 	} else if valueType == TokenStringLiteral || valueType == TokenTrue || valueType == TokenFalse || valueType == TokenNull || valueType == TokenNumber {
 		// Nothing to do here, the value is a literal; store index and length
 
@@ -273,90 +361,171 @@ func (p *Parser) _ParseJSONElement(label string, valueToken Token) *Element {
 		valid = false
 	}
 
-	var result *Element
-
+	var result P
 	if valid {
-		result = new(Element)
-		result.Label = label
-		result.Value = p.ExtractTokenValue(valueToken)
-		result.FirstSubElement = subElement
-		result.NextSibling = nil
+		result = newElement(labelToken, valueToken, subElement)
 	}
 
 	return result
 }
 
+// newElement is the parser's node constructor for Element trees.
+func (p *Parser) newElement(labelToken Token, valueToken Token, subElement *Element) *Element {
+	result := new(Element)
+	if labelToken.Type == TokenStringLiteral {
+		result.Label = p.tokenString(labelToken)
+	}
+	result.Value = p.tokenString(valueToken)
+	result.FirstSubElement = subElement
+	return result
+}
+
 func (p *Parser) ParseJSON(inputsJSON []byte) *Element {
+	return parseJSONRoot(p, p.newElement, inputsJSON)
+}
+
+// ParseJSONArena is ParseJSON into arena, which keeps inputJSON and reads the
+// tree's text from it. Input larger than MaxArenaSourceSize is an error.
+func (p *Parser) ParseJSONArena(inputJSON []byte, arena *ElementArena) *ArenaElement {
+	if len(inputJSON) > MaxArenaSourceSize {
+		p.Source = inputJSON
+		p.HadError = true
+		p.Errors = append(p.Errors, ParseError{
+			Line:     1,
+			Column:   1,
+			Expected: fmt.Sprintf("at most %d bytes of input for an ElementArena", MaxArenaSourceSize),
+			Found:    fmt.Sprintf("%d bytes", len(inputJSON)),
+		})
+		return nil
+	}
+	arena.source = inputJSON
+	return parseJSONRoot(p, func(labelToken Token, valueToken Token, subElement *ArenaElement) *ArenaElement {
+		return arena.newElement(p, labelToken, valueToken, subElement)
+	}, inputJSON)
+}
+
+func parseJSONRoot[N any, P treeNode[N]](p *Parser, newElement func(labelToken Token, valueToken Token, subElement P) P, inputsJSON []byte) P {
 	p.Source = inputsJSON
 	p.At = 0
 	p.HadError = false
 	token := p.GetJSONToken()
-	return p._ParseJSONElement("", token)
+	root := parseJSONElement(p, newElement, Token{}, token)
+
+	if p.Strict {
+		if root == nil {
+			p.Error(token, "a JSON value")
+		} else {
+			p.checkEnd()
+		}
+	}
+	return root
 }
 
-func ConvertElementToFloat64(object *Element, elementName string) float64 {
-	result := 0.0
+var errNoPairsArray = fmt.Errorf("%w: no \"pairs\" array in the JSON", shared.ErrMalformedInput)
+
+// ConvertElementToFloat64 converts the value of the named field of object with
+// convert. A missing field or a value that isn't a number is an error wrapping
+// shared.ErrMalformedInput.
+func ConvertElementToFloat64(object *Element, elementName string, convert FloatConverter) (float64, error) {
 	element := LookupElement(object, elementName)
+	if element == nil {
+		return 0, fmt.Errorf("%w: missing field %q", shared.ErrMalformedInput, elementName)
+	}
 
-	if element != nil {
-		source := element.Value
-		at := 0
+	result, err := convert(element.valueBytes())
+	if err != nil {
+		return 0, fmt.Errorf("field %q: %w", elementName, err)
+	}
+	return result, nil
+}
 
-		sign := 1.0
-		if len(source) > at && source[at] == '-' {
-			sign = -1.0
+// ConvertJSONNumber converts the text of a JSON number token to a float64 by
+// accumulating digits in a float64. It is the FloatNaive converter: quick to
+// write, but not correctly rounded.
+func ConvertJSONNumber(source []byte) (float64, error) {
+	if err := CheckJSONNumber(source); err != nil {
+		return 0, err
+	}
+	at := 0
+
+	sign := 1.0
+	if len(source) > at && source[at] == '-' {
+		sign = -1.0
+		at++
+	}
+
+	//Convert number
+	number := 0.0
+	for len(source) > at && IsJSONDigit(source, at) {
+		char := float64(source[at] - '0') // converts ASCII values to numerical value
+		number = 10.0*number + char
+		at++
+	}
+
+	//Handle decimal
+	if len(source) > at && source[at] == '.' {
+		at++
+		c := 1.0 / 10.0
+		for len(source) > at && IsJSONDigit(source, at) {
+			char := source[at] - '0'
+			number = number + c*float64(char)
+			c *= 1.0 / 10.0
 			at++
 		}
+	}
 
-		//Convert number
-		number := 0.0
-		for len(source) > at && IsJSONDigit([]byte(source), at) {
-			char := float64(source[at] - '0') // converts ASCII values to numerical value
-			number = 10.0*number + char
+	//Handle scientific notation
+	if len(source) > at && (source[at] == 'e' || source[at] == 'E') {
+		at++
+		exponentSign := 1
+		if len(source) > at && (source[at] == '+' || source[at] == '-') {
+			if source[at] == '-' {
+				exponentSign = -1
+			}
 			at++
 		}
 
-		//Handle decimal
-		if len(source) > at && source[at] == '.' {
-			at++
-			c := 1.0 / 10.0
-			for len(source) > at && IsJSONDigit([]byte(source), at) {
-				char := source[at] - '0'
-				number = number + c*float64(char)
-				c *= 1.0 / 10.0
-				at++
+		exponent := 0
+		for len(source) > at && IsJSONDigit(source, at) {
+			if exponent < 100_000 { // already far past any float64, and can't overflow
+				exponent = 10*exponent + int(source[at]-'0')
 			}
+			at++
 		}
 
-		//Handle scientific notation
-		if len(source) > at && (source[at] == 'e' || source[at] == 'E') {
-			at++
-			exponentSign := 1.0
-			if len(source) > at && (source[at] == '+' || source[at] == '-') {
-				if source[at] == '-' {
-					exponentSign = -1.0
-				}
-				at++
-			}
+		number = scaleByPow10(number, exponentSign*exponent)
+	}
+	return sign * number, nil
+}
 
-			exponent := 0.0
-			for len(source) > at && IsJSONDigit([]byte(source), at) {
-				char := source[at] - '0'
-				exponent = 10.0*exponent + float64(char)
-				at++
-			}
+// scaleByPow10 returns number * 10^exponent, saturating to Inf or 0.
+// Negative exponents divide by 10^-exponent instead of multiplying by its
+// reciprocal, which underflows to 0 long before number/10^n does.
+func scaleByPow10(number float64, exponent int) float64 {
+	if number == 0 {
+		return number // 0 * Inf would be NaN
+	}
+	if exponent >= 0 {
+		return number * pow(10, exponent)
+	}
 
-			number *= pow(10.0, exponentSign*exponent)
-		}
-		result = sign * number
+	// 10^n itself overflows past 308, so divide in steps
+	for exponent < -308 && number != 0 {
+		number /= 1e308
+		exponent += 308
 	}
-	return result
+	return number / pow(10, -exponent)
 }
 
-func pow(x, y float64) float64 {
+// pow computes x^y for y >= 0 by repeated squaring.
+func pow(x float64, y int) float64 {
 	result := 1.0
-	for range int(y) {
-		result *= x
+	for y > 0 {
+		if y&1 != 0 {
+			result *= x
+		}
+		x *= x
+		y >>= 1
 	}
 	return result
 }
@@ -366,7 +535,7 @@ func LookupElement(object *Element, elementName string) *Element {
 
 	if object != nil {
 		for search := object.FirstSubElement; search != nil; search = search.NextSibling {
-			if search.Label == elementName {
+			if search.labelIs(elementName) {
 				result = search
 				break
 			}
@@ -379,27 +548,68 @@ func IsInBounds(source []byte, at int) bool {
 	return at >= 0 && at < len(source)
 }
 
-func ParseHaversinePairs(inputJSON []byte, maxPairCount int, pairs []shared.HaversinePair) int {
+// ParseHaversinePairs parses inputJSON into an Element tree, or an
+// ArenaElement tree with parseOpts.Arena, then converts the
+// coordinates of the "pairs" array. A coordinate that is missing or can't be
+// converted stops it with an error naming the pair. Syntax errors, including
+// input that ends early, are returned as ParseErrors.
+func ParseHaversinePairs(inputJSON []byte, maxPairCount int, pairs []shared.HaversinePair, parseOpts ParseOptions) (int, error) {
 	defer timing.TimeFunction()()
-	parser := Parser{Source: inputJSON}
+	parser := Parser{Source: inputJSON, Strict: parseOpts.Strict}
+	convert := parseOpts.converter()
+
+	if parseOpts.Arena == nil {
+		stopTimer := timing.TimeBandwidth("Parse JSON", uint64(len(inputJSON)))
+		JSON := parser.ParseJSON(inputJSON)
+		stopTimer()
+		if parser.HadError {
+			return 0, parser.Errors
+		}
+
+		return convertPairs(LookupElement(JSON, "pairs"), maxPairCount, pairs, func(object *Element, name string) (float64, error) {
+			return ConvertElementToFloat64(object, name, convert)
+		})
+	}
 
-	stopTimer := timing.TimeBlock("Parse JSON")
-	JSON := parser.ParseJSON(inputJSON)
+	arena := parseOpts.Arena
+	stopTimer := timing.TimeBandwidth("Parse JSON", uint64(len(inputJSON)))
+	JSON := parser.ParseJSONArena(inputJSON, arena)
 	stopTimer()
+	if parser.HadError {
+		return 0, parser.Errors
+	}
+
+	return convertPairs(arena.LookupElement(JSON, "pairs"), maxPairCount, pairs, func(object *ArenaElement, name string) (float64, error) {
+		return arena.ConvertElementToFloat64(object, name, convert)
+	})
+}
+
+// convertPairs converts the pair objects of pairsArray with convertField,
+// which converts one named field of an object.
+func convertPairs[N any, P treeNode[N]](pairsArray P, maxPairCount int, pairs []shared.HaversinePair, convertField func(object P, name string) (float64, error)) (int, error) {
+	if pairsArray == nil {
+		return 0, errNoPairsArray
+	}
+
 	pairCount := 0
-	pairsArray := LookupElement(JSON, "pairs")
-
-	if pairsArray != nil {
-		stopTimer = timing.TimeBlock("Convert to Float")
-		for element := pairsArray.FirstSubElement; element != nil && pairCount < maxPairCount; element = element.NextSibling {
-			pair := &pairs[pairCount]
-			pair.X0 = ConvertElementToFloat64(element, "X0")
-			pair.Y0 = ConvertElementToFloat64(element, "Y0")
-			pair.X1 = ConvertElementToFloat64(element, "X1")
-			pair.Y1 = ConvertElementToFloat64(element, "Y1")
-			pairCount++
+	stopTimer := timing.TimeBlock("Convert to Float")
+	defer stopTimer()
+	for element := P(pairsArray.firstSubElement()); element != nil && pairCount < maxPairCount; element = element.nextSibling() {
+		pair := &pairs[pairCount]
+		var err error
+		if pair.X0, err = convertField(element, "X0"); err != nil {
+			return pairCount, fmt.Errorf("pair %d: %w", pairCount, err)
 		}
-		stopTimer()
+		if pair.Y0, err = convertField(element, "Y0"); err != nil {
+			return pairCount, fmt.Errorf("pair %d: %w", pairCount, err)
+		}
+		if pair.X1, err = convertField(element, "X1"); err != nil {
+			return pairCount, fmt.Errorf("pair %d: %w", pairCount, err)
+		}
+		if pair.Y1, err = convertField(element, "Y1"); err != nil {
+			return pairCount, fmt.Errorf("pair %d: %w", pairCount, err)
+		}
+		pairCount++
 	}
-	return pairCount
+	return pairCount, nil
 }
@@ -0,0 +1,81 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ryank157/perfAware/internal/shared"
+)
+
+// ParseError is a syntax error found by the Parser.
+type ParseError struct {
+	Offset   int    // byte offset into the source
+	Line     int    // 1-based
+	Column   int    // 1-based, in bytes
+	Token    string // source text of the offending token, "" at the end of the input
+	Expected string // what the parser was looking for
+	Found    string // what it got instead
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d (offset %d): expected %s, found %s", e.Line, e.Column, e.Offset, e.Expected, e.Found)
+}
+
+// ParseErrors are the errors collected by one parse. As an error it matches
+// shared.ErrMalformedInput with errors.Is.
+type ParseErrors []ParseError
+
+func (e ParseErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, parseError := range e {
+		messages[i] = parseError.Error()
+	}
+	return fmt.Sprintf("%v: %s", shared.ErrMalformedInput, strings.Join(messages, "; "))
+}
+
+func (e ParseErrors) Unwrap() error {
+	return shared.ErrMalformedInput
+}
+
+var tokenTypeNames = [TokenCount]string{
+	TokenEndOfStream:   "end of input",
+	TokenError:         "invalid token",
+	TokenOpenBrace:     "'{'",
+	TokenOpenBracket:   "'['",
+	TokenCloseBrace:    "'}'",
+	TokenCloseBracket:  "']'",
+	TokenComma:         "','",
+	TokenColon:         "':'",
+	TokenSemiColon:     "';'",
+	TokenStringLiteral: "string",
+	TokenNumber:        "number",
+	TokenTrue:          "true",
+	TokenFalse:         "false",
+	TokenNull:          "null",
+}
+
+// describeToken is the Found text of an error at token.
+func (p *Parser) describeToken(token Token) string {
+	if token.Type == TokenError && p.tokenProblem != "" {
+		return p.tokenProblem
+	}
+	switch token.Type {
+	case TokenError:
+		return fmt.Sprintf("%q", p.ExtractTokenValue(token))
+	case TokenStringLiteral, TokenNumber:
+		return fmt.Sprintf("%s %q", tokenTypeNames[token.Type], p.ExtractTokenValue(token))
+	default:
+		if token.Type >= 0 && token.Type < TokenCount {
+			return tokenTypeNames[token.Type]
+		}
+		return fmt.Sprintf("token type %d", token.Type)
+	}
+}
+
+// closerFor is what the parser expects next inside a list ending with endType.
+func closerFor(endType int) string {
+	if endType == TokenCloseBrace {
+		return "',' or '}'"
+	}
+	return "',' or ']'"
+}
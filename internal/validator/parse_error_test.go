@@ -0,0 +1,73 @@
+package validator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ryank157/perfAware/internal/shared"
+)
+
+func TestTruncatedInputIsAnError(t *testing.T) {
+	input := `{"pairs":[{"X0":1.5,"Y0":-2,"X1":3,"Y1":4},{"X0":5,"Y0":6,"X1":7,"Y1":8}]}`
+	pairs := make([]shared.HaversinePair, 4)
+
+	parsers := map[string]func([]byte, int, []shared.HaversinePair, ParseOptions) (int, error){
+		ParserTree:   ParseHaversinePairs,
+		ParserStream: ParseHaversinePairsStreaming,
+	}
+	for name, parse := range parsers {
+		count, err := parse([]byte(input), len(pairs), pairs, ParseOptions{})
+		if err != nil || count != 2 {
+			t.Fatalf("%s: complete input gave %d pairs, %v", name, count, err)
+		}
+
+		// Every proper prefix must fail, including ones ending right after a pair
+		for length := range len(input) {
+			_, err := parse([]byte(input[:length]), len(pairs), pairs, ParseOptions{})
+			if !errors.Is(err, shared.ErrMalformedInput) {
+				t.Errorf("%s: %q was accepted (err %v)", name, input[:length], err)
+			}
+		}
+	}
+}
+
+func TestParseErrorDetails(t *testing.T) {
+	input := "{\"pairs\": [\n  {\"X0\": 1 \"Y0\": 2}\n]}"
+	pairs := make([]shared.HaversinePair, 4)
+
+	for _, parse := range []func([]byte, int, []shared.HaversinePair, ParseOptions) (int, error){ParseHaversinePairs, ParseHaversinePairsStreaming} {
+		_, err := parse([]byte(input), len(pairs), pairs, ParseOptions{})
+		var parseErrors ParseErrors
+		if !errors.As(err, &parseErrors) || len(parseErrors) != 1 {
+			t.Fatalf("got %v, want one ParseError", err)
+		}
+
+		want := ParseError{Offset: 24, Line: 2, Column: 13, Token: "Y0", Expected: "',' or '}'", Found: `string "Y0"`}
+		if parseErrors[0] != want {
+			t.Errorf("got %+v, want %+v", parseErrors[0], want)
+		}
+	}
+}
+
+func TestTruncatedPairMatchesTreeParser(t *testing.T) {
+	pairs := make([]shared.HaversinePair, 4)
+	for _, input := range []string{
+		`{"pairs":[{`,
+		`{"pairs":[{"X0":1.5,`,
+		`{"pairs":[{"X0":1.5,"Y0":2,`,
+		`{"pairs":[{"X0":1.5,"Y0":2,"X1":3,"Y1":4},`,
+	} {
+		var want, got ParseErrors
+		_, treeErr := ParseHaversinePairs([]byte(input), len(pairs), pairs, ParseOptions{})
+		_, streamErr := ParseHaversinePairsStreaming([]byte(input), len(pairs), pairs, ParseOptions{})
+		if !errors.As(treeErr, &want) || !errors.As(streamErr, &got) {
+			t.Fatalf("%q: got %v and %v, want ParseErrors from both parsers", input, treeErr, streamErr)
+		}
+		if len(got) != 1 || got[0] != want[0] {
+			t.Errorf("%q: stream parser got %v, tree parser %v", input, got, want)
+		}
+		if got[0].Offset != len(input) || got[0].Found != "end of input" {
+			t.Errorf("%q: got %+v, want the end of input", input, got[0])
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/ryank157/perfAware/internal/shared"
+	"github.com/ryank157/perfAware/internal/timing"
+)
+
+// ParseOptions configures ParseHaversinePairs and ParseHaversinePairsStreaming.
+type ParseOptions struct {
+	Convert FloatConverter // nil selects ParseFloatFast
+	Strict  bool           // see Parser.Strict
+	Arena   *ElementArena  // tree parser only: parse into this arena instead of new(Element) per value
+}
+
+func (o ParseOptions) converter() FloatConverter {
+	if o.Convert == nil {
+		return ParseFloatFast
+	}
+	return o.Convert
+}
+
+// Parser names selectable from cmd/validate.
+const (
+	ParserTree   = "tree"   // ParseJSON into an Element tree, then convert
+	ParserStream = "stream" // pull tokens and write straight into the pairs slice
+)
+
+// TokenBytes returns the source bytes of a token without copying them.
+func (p *Parser) TokenBytes(token Token) []byte {
+	if !IsInBounds(p.Source, token.Start) || token.Length <= 0 || token.Start+token.Length > len(p.Source) {
+		return nil
+	}
+	return p.Source[token.Start : token.Start+token.Length]
+}
+
+// ParseHaversinePairsStreaming reads the "pairs" array one token at a time and
+// converts each coordinate directly into pairs. No Elements or
+// strings are allocated, so it can be compared against ParseHaversinePairs.
+// Errors are returned like ParseHaversinePairs does.
+func ParseHaversinePairsStreaming(inputJSON []byte, maxPairCount int, pairs []shared.HaversinePair, parseOpts ParseOptions) (int, error) {
+	defer timing.TimeFunction()()
+	parser := Parser{Source: inputJSON, Strict: parseOpts.Strict}
+	convert := parseOpts.converter()
+
+	stopTimer := timing.TimeBandwidth("Parse JSON", uint64(len(inputJSON)))
+	defer stopTimer()
+
+	pairCount := 0
+	open := parser.GetJSONToken()
+	if open.Type != TokenOpenBrace {
+		parser.Error(open, "'{' at the start of the JSON")
+		return 0, parser.Errors
+	}
+
+	// Nested lists only return early at an error or the end of the input, so
+	// reaching the outer '}' means every value before it was closed too
+	closed := false
+	foundPairs := false
+	for first := true; parser.IsParsing(); first = false {
+		key := parser.GetJSONToken()
+		if key.Type == TokenCloseBrace {
+			parser.checkTrailingComma(key, first)
+			closed = true
+			break
+		}
+		if key.Type != TokenStringLiteral {
+			parser.Error(key, "a field name or '}'")
+			break
+		}
+		if !parser.expectToken(TokenColon, "':' after the field name") {
+			break
+		}
+
+		value := parser.GetJSONToken()
+		if string(parser.keyBytes(key)) == "pairs" && value.Type == TokenOpenBracket {
+			var err error
+			foundPairs = true
+			pairCount, err = parser.streamPairsArray(value, maxPairCount, pairs, convert)
+			if err != nil {
+				return pairCount, err
+			}
+		} else {
+			parser.skipValue(value)
+		}
+
+		comma := parser.GetJSONToken()
+		if comma.Type == TokenCloseBrace {
+			closed = true
+			break
+		} else if comma.Type != TokenComma {
+			parser.Error(comma, closerFor(TokenCloseBrace))
+		}
+	}
+	if !closed {
+		parser.errorAtEnd(open, "'}'")
+	}
+	parser.checkEnd()
+
+	if parser.HadError {
+		return pairCount, parser.Errors
+	}
+	if !foundPairs {
+		return 0, errNoPairsArray
+	}
+	return pairCount, nil
+}
+
+// streamPairsArray parses the objects of the pairs array; the opening [, open,
+// has already been consumed.
+func (p *Parser) streamPairsArray(open Token, maxPairCount int, pairs []shared.HaversinePair, convert FloatConverter) (int, error) {
+	pairCount := 0
+	closed := false
+	for first := true; p.IsParsing(); first = false {
+		openPair := p.GetJSONToken()
+		if openPair.Type == TokenCloseBracket {
+			p.checkTrailingComma(openPair, first)
+			closed = true
+			break
+		}
+		if openPair.Type != TokenOpenBrace {
+			p.Error(openPair, "'{' to start a pair or ']'")
+			break
+		}
+
+		var pair shared.HaversinePair
+		if err := p.streamPairObject(openPair, &pair, convert); err != nil {
+			return pairCount, fmt.Errorf("pair %d: %w", pairCount, err)
+		}
+		if pairCount < maxPairCount {
+			pairs[pairCount] = pair
+			pairCount++
+		}
+
+		comma := p.GetJSONToken()
+		if comma.Type == TokenCloseBracket {
+			closed = true
+			break
+		} else if comma.Type != TokenComma {
+			p.Error(comma, closerFor(TokenCloseBracket))
+		}
+	}
+	if !closed {
+		p.errorAtEnd(open, "']'")
+	}
+	return pairCount, nil
+}
+
+// streamPairObject fills pair from the fields of one object; the opening {,
+// open, has already been consumed. Unknown fields are skipped, missing
+// coordinates are an error.
+func (p *Parser) streamPairObject(open Token, pair *shared.HaversinePair, convert FloatConverter) error {
+	fieldNames := [4]string{"X0", "Y0", "X1", "Y1"}
+	fields := [4]*float64{&pair.X0, &pair.Y0, &pair.X1, &pair.Y1}
+	found := 0 // bit i set once fieldNames[i] was seen
+
+	closed := false
+	for first := true; p.IsParsing(); first = false {
+		key := p.GetJSONToken()
+		if key.Type == TokenCloseBrace {
+			p.checkTrailingComma(key, first)
+			closed = true
+			break
+		}
+		if key.Type != TokenStringLiteral {
+			p.Error(key, "a field name or '}'")
+			return nil
+		}
+		if !p.expectToken(TokenColon, "':' after the field name") {
+			return nil
+		}
+
+		value := p.GetJSONToken()
+		field := slices.Index(fieldNames[:], string(p.keyBytes(key)))
+		if field >= 0 {
+			number, err := convert(p.TokenBytes(value))
+			if err != nil {
+				return fmt.Errorf("field %q: %w", fieldNames[field], err)
+			}
+			*fields[field] = number
+			found |= 1 << field
+		} else {
+			p.skipValue(value)
+		}
+
+		comma := p.GetJSONToken()
+		if comma.Type == TokenCloseBrace {
+			closed = true
+			break
+		} else if comma.Type != TokenComma {
+			p.Error(comma, closerFor(TokenCloseBrace))
+		}
+	}
+	if !closed {
+		p.errorAtEnd(open, "'}'")
+	}
+
+	if p.HadError {
+		return nil
+	}
+	for i, name := range fieldNames {
+		if found&(1<<i) == 0 {
+			return fmt.Errorf("%w: missing field %q", shared.ErrMalformedInput, name)
+		}
+	}
+	return nil
+}
+
+func (p *Parser) expectToken(tokenType int, expected string) bool {
+	token := p.GetJSONToken()
+	if token.Type != tokenType {
+		p.Error(token, expected)
+		return false
+	}
+	return true
+}
+
+// skipValue consumes the rest of a value whose first token has been read. In
+// strict mode the value is parsed properly, so it is fully checked.
+func (p *Parser) skipValue(value Token) {
+	if p.Strict {
+		if parseJSONElement(p, p.newElement, Token{}, value) == nil {
+			p.Error(value, "a value")
+		}
+		return
+	}
+
+	switch value.Type {
+	case TokenOpenBrace, TokenOpenBracket:
+		depth := 1
+		for depth > 0 && p.IsParsing() {
+			switch p.GetJSONToken().Type {
+			case TokenOpenBrace, TokenOpenBracket:
+				depth++
+			case TokenCloseBrace, TokenCloseBracket:
+				depth--
+			case TokenError:
+				p.Error(value, "a value")
+				return
+			}
+		}
+	case TokenStringLiteral, TokenNumber, TokenTrue, TokenFalse, TokenNull:
+	default:
+		p.Error(value, "a value")
+	}
+}
+
+// keyBytes is TokenBytes for a field name, with escapes decoded in strict mode.
+func (p *Parser) keyBytes(key Token) []byte {
+	raw := p.TokenBytes(key)
+	if p.Strict && bytes.IndexByte(raw, '\\') >= 0 {
+		return []byte(p.tokenString(key))
+	}
+	return raw
+}
+
+// checkTrailingComma reports a list closed right after a comma, in strict mode.
+func (p *Parser) checkTrailingComma(closing Token, first bool) {
+	if p.Strict && !first {
+		p.Error(closing, "a value after ','")
+	}
+}
+
+// checkEnd reports anything after the top level value, in strict mode.
+func (p *Parser) checkEnd() {
+	if p.Strict {
+		if trailing := p.GetJSONToken(); trailing.Type != TokenEndOfStream || p.At < len(p.Source) {
+			p.Error(trailing, "the end of the input after the JSON value")
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/ryank157/perfAware/internal/shared"
+)
+
+// Strict mode (Parser.Strict) accepts exactly the RFC 8259 grammar. On top of
+// the loose tokenizer it rejects ';', numbers with leading zeros, a lone '-' or
+// a bare '.', strings with control characters, bad escapes or invalid UTF-8,
+// trailing commas, missing values and anything after the top level value.
+// String labels and values are stored with their escapes decoded.
+
+// Position converts a byte offset in the source into a 1-based line and
+// column. Columns count bytes, not characters.
+func (p *Parser) Position(offset int) (line int, column int) {
+	offset = min(max(offset, 0), len(p.Source))
+	line = 1 + bytes.Count(p.Source[:offset], []byte{'\n'})
+	column = offset - (bytes.LastIndexByte(p.Source[:offset], '\n') + 1) + 1
+	return line, column
+}
+
+// strictStringToken scans the string literal whose opening quote is at at and
+// returns its token, which excludes the quotes, and the offset after the
+// closing quote. A malformed string gives a TokenError at the offending byte.
+func (p *Parser) strictStringToken(at int) (Token, int) {
+	source := p.Source
+	start := at + 1
+	fail := func(problemAt int, problem string) (Token, int) {
+		p.tokenProblem = problem
+		return Token{Type: TokenError, Start: problemAt, Length: 1}, problemAt
+	}
+
+	for at = start; at < len(source); {
+		c := source[at]
+		switch {
+		case c == '"':
+			return Token{Type: TokenStringLiteral, Start: start, Length: at - start}, at + 1
+		case c < 0x20:
+			return fail(at, "control character in string, it must be escaped")
+		case c == '\\':
+			if at+1 >= len(source) {
+				return fail(at, "unterminated string")
+			}
+			switch source[at+1] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				at += 2
+			case 'u':
+				if _, ok := hex4(source, at+2); !ok {
+					return fail(at, "expected four hex digits after \\u")
+				}
+				at += 6
+			default:
+				return fail(at, fmt.Sprintf("invalid escape \\%c", source[at+1]))
+			}
+		case c < utf8.RuneSelf:
+			at++
+		default:
+			r, size := utf8.DecodeRune(source[at:])
+			if r == utf8.RuneError && size <= 1 {
+				return fail(at, "invalid UTF-8 in string")
+			}
+			at += size
+		}
+	}
+	return fail(start-1, "unterminated string")
+}
+
+// tokenString is the text of a token as stored in an Element: in strict mode
+// string literals have their escapes decoded, otherwise the source is copied
+// as is.
+func (p *Parser) tokenString(token Token) string {
+	if p.Strict && token.Type == TokenStringLiteral {
+		if value, err := DecodeJSONString(p.TokenBytes(token)); err == nil {
+			return value
+		}
+	}
+	return p.ExtractTokenValue(token)
+}
+
+// DecodeJSONString decodes the escapes in the contents of a JSON string
+// literal, quotes excluded. \u escapes of UTF-16 surrogate pairs are combined;
+// a lone surrogate becomes U+FFFD.
+func DecodeJSONString(raw []byte) (string, error) {
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw), nil
+	}
+
+	decoded := make([]byte, 0, len(raw))
+	for at := 0; at < len(raw); {
+		c := raw[at]
+		if c != '\\' {
+			decoded = append(decoded, c)
+			at++
+			continue
+		}
+		if at+1 >= len(raw) {
+			return "", fmt.Errorf("%w: unterminated escape", shared.ErrMalformedInput)
+		}
+
+		switch escape := raw[at+1]; escape {
+		case '"', '\\', '/':
+			decoded = append(decoded, escape)
+		case 'b':
+			decoded = append(decoded, '\b')
+		case 'f':
+			decoded = append(decoded, '\f')
+		case 'n':
+			decoded = append(decoded, '\n')
+		case 'r':
+			decoded = append(decoded, '\r')
+		case 't':
+			decoded = append(decoded, '\t')
+		case 'u':
+			unit, ok := hex4(raw, at+2)
+			if !ok {
+				return "", fmt.Errorf("%w: expected four hex digits after \\u", shared.ErrMalformedInput)
+			}
+			at += 6
+			r := rune(unit)
+			if utf16.IsSurrogate(r) {
+				r = utf8.RuneError
+				if at+1 < len(raw) && raw[at] == '\\' && raw[at+1] == 'u' {
+					if low, ok := hex4(raw, at+2); ok {
+						if pair := utf16.DecodeRune(rune(unit), rune(low)); pair != utf8.RuneError {
+							r = pair
+							at += 6
+						}
+					}
+				}
+			}
+			decoded = utf8.AppendRune(decoded, r)
+			continue
+		default:
+			return "", fmt.Errorf("%w: invalid escape \\%c", shared.ErrMalformedInput, escape)
+		}
+		at += 2
+	}
+	return string(decoded), nil
+}
+
+// hex4 decodes the four hex digits at source[at:].
+func hex4(source []byte, at int) (uint16, bool) {
+	if at+4 > len(source) {
+		return 0, false
+	}
+	value := uint16(0)
+	for _, c := range source[at : at+4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		value = value<<4 | uint16(c)
+	}
+	return value, true
+}
@@ -2,10 +2,9 @@ package validator
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
 	"math"
-	"os"
 	"unsafe"
 
 	"github.com/ryank157/perfAware/internal/shared"
@@ -21,16 +20,111 @@ type ValidateTimers struct {
 	End        uint64
 }
 
-func ValidateData(inputFileName string, answersFileName string) {
+// Options controls the optional parts of ValidateData.
+type Options struct {
+	Parser              string        // ParserTree (default) or ParserStream
+	Float               string        // FloatFast (default), FloatStrconv or FloatNaive
+	Strict              bool          // reject anything that isn't RFC 8259 JSON
+	Alloc               string        // tree parser Element allocation: AllocGC (default) or AllocArena
+	ArenaSlabSize       int           // Elements per arena slab, 0 for DefaultArenaSlabSize
+	Arena               *ElementArena // with AllocArena, reset and reused instead of making a new arena
+	PairAnswersFileName string        // per-pair reference file written by the generator, "" to skip
+	MaxMismatches       int           // how many mismatching pairs to report
+	Tolerance           float64       // largest absolute difference from the reference sum that still passes
+	Threads             int           // 0 sums on this goroutine, otherwise the worker count for the parallel sum
+	SumMethod           string        // summation method name, "" to use the one recorded in the answer file
+	SkipHash            bool          // don't hash the input to check it against the answer file
+}
+
+// Result is what ValidateData measured. Passed is false when the computed sum
+// is further than Tolerance from the reference sum.
+type Result struct {
+	InputFormat  string // "json" or "binary"
+	Answer       shared.Answer
+	InputBytes   int64
+	PairCount    int
+	SumMethod    shared.SumMethod
+	Sum          float64
+	ReferenceSum float64
+	AbsDiff      float64
+	RelDiff      float64
+	ULPDiff      uint64
+	Tolerance    float64
+	Passed       bool
+	Pairs        *PairCheck // nil unless Options.PairAnswersFileName was set
+}
+
+// ValidateData parses the input, sums the pair distances and compares the sum
+// against the answer file. Failures are returned wrapping one of the shared
+// error classes (shared.ErrIO, shared.ErrMalformedInput, shared.ErrAnswerSize,
+// shared.ErrAnswerMalformed, shared.ErrDataSetMismatch when the answer file was generated for other input);
+// a sum outside the tolerance is not an error, it is reported in Result.Passed.
+func ValidateData(inputFileName string, answersFileName string, opts Options) (Result, error) {
+	var result Result
 	inputJSONBuffer, err := shared.ReadEntireFile(inputFileName)
 	if err != nil {
-		log.Fatalf("Error reading JSON file: %v", err)
+		return result, fmt.Errorf("reading JSON file: %w", err)
 	}
 	defer shared.FreeBuffer(&inputJSONBuffer) // VERY IMPORTANT: Release memory
 
+	// Read the answer first, it decides how the distances are summed
+	answersF64Buffer, err := shared.ReadEntireFile(answersFileName)
+	if err != nil {
+		return result, fmt.Errorf("reading answer file: %w", err)
+	}
+	defer shared.FreeBuffer(&answersF64Buffer)
+
+	answer, err := shared.ParseAnswer(answersF64Buffer.Data)
+	if err != nil {
+		return result, err
+	}
+	result.Answer = answer
+
+	// Legacy answer files were always summed naively by the generator
+	sumMethod := answer.SumMethod
+	if opts.SumMethod != "" {
+		sumMethod, err = shared.ParseSumMethod(opts.SumMethod)
+		if err != nil {
+			return result, err
+		}
+	}
+	result.SumMethod = sumMethod
+
+	convert, err := FloatConverterByName(opts.Float)
+	if err != nil {
+		return result, err
+	}
+	parseOpts := ParseOptions{Convert: convert, Strict: opts.Strict}
+	if opts.Alloc == AllocArena {
+		parseOpts.Arena = opts.Arena
+		if parseOpts.Arena == nil {
+			parseOpts.Arena = NewElementArena(opts.ArenaSlabSize)
+		}
+		parseOpts.Arena.Reset() // whatever was parsed into it before is dropped
+	}
+
+	// Binary pairs files are recognized by their magic and skip parsing entirely
+	isBinary := shared.IsPairsFile(inputJSONBuffer.Data)
+	result.InputFormat = "json"
+
 	minimumJSONPairEncoding := 6 * 4 // Minimal size based on C's u32
 
 	maxPairCount := inputJSONBuffer.Count / int64(minimumJSONPairEncoding)
+	var header *shared.PairsHeader
+	if isBinary {
+		pairsHeader, err := shared.ReadPairsHeader(inputJSONBuffer.Data)
+		if err != nil {
+			return result, err
+		}
+		header = &pairsHeader
+		maxPairCount = int64(header.PairCount)
+		result.InputFormat = "binary"
+	}
+
+	// Catch a mismatched answer file before spending any time on parsing
+	if err := checkDataSet(answer, inputJSONBuffer.Data, header, !opts.SkipHash); err != nil {
+		return result, err
+	}
 	if maxPairCount > 0 {
 		parsedValuesBuffer := shared.AllocateBuffer(maxPairCount * int64(unsafe.Sizeof(shared.HaversinePair{}))) //Use unsafe.Sizeof!
 		if parsedValuesBuffer.Count > 0 {
@@ -41,44 +135,158 @@ func ValidateData(inputFileName string, answersFileName string) {
 			// and it's essential for working with the byte-oriented buffer.
 			pairs := unsafe.Slice((*shared.HaversinePair)(unsafe.Pointer(&parsedValuesBuffer.Data[0])), maxPairCount)
 
-			pairCount := ParseHaversinePairs(inputJSONBuffer.Data, int(maxPairCount), pairs)
-			sum := shared.SumHaversineDistances(pairCount, pairs[:pairCount]) // Slice only the populated part of the pair
-			fmt.Printf("Input size: %d\n", inputJSONBuffer.Count)
-			fmt.Printf("Pair count: %d\n", pairCount)
-			fmt.Printf("Haversine sum: %.16f\n", sum)
-
-			answersF64Buffer, err := shared.ReadEntireFile(answersFileName)
+			var pairCount int
+			var err error
+			if isBinary {
+				pairCount = shared.DecodePairs(inputJSONBuffer.Data, pairs)
+			} else if opts.Parser == ParserStream {
+				pairCount, err = ParseHaversinePairsStreaming(inputJSONBuffer.Data, int(maxPairCount), pairs, parseOpts)
+			} else {
+				pairCount, err = ParseHaversinePairs(inputJSONBuffer.Data, int(maxPairCount), pairs, parseOpts)
+			}
 			if err != nil {
-				log.Printf("Warning: Error reading answer file: %v", err) // Don't crash if validation fails
-				return                                                    // Important to RETURN here
+				return result, err
 			}
-
-			defer shared.FreeBuffer(&answersF64Buffer)
-
-			if answersF64Buffer.Count != int64(unsafe.Sizeof(float64(0))) {
-				log.Printf("Warning: Answer file size incorrect. Expected %d bytes,  got %d. Aborting  validation", int64(unsafe.Sizeof(float64(0))), answersF64Buffer.Count)
-				return // Important to RETURN here
+			if answer.DescribesDataSet() && uint64(pairCount) != answer.PairCount {
+				return result, fmt.Errorf("%w: parsed %d pairs, the answer file is for %d", shared.ErrDataSetMismatch, pairCount, answer.PairCount)
+			}
+			var sum float64
+			if opts.Threads > 0 {
+				sum = shared.SumHaversineDistancesParallel(pairs[:pairCount], opts.Threads, sumMethod)
+			} else {
+				sum = shared.SumHaversineDistances(pairCount, pairs[:pairCount], sumMethod) // Slice only the populated part of the pair
 			}
+			result.InputBytes = inputJSONBuffer.Count
+			result.PairCount = pairCount
+			result.Sum = sum
 
-			//was sizeof(double)  in C world
+			refSumFloat := answer.Average
 
-			//THIS IS WHAT C VERSION DOES.  We read directly *from the buffer.* No need
-			//to play with the last element of the array and similar nonsense!
-			bits := binary.LittleEndian.Uint64(answersF64Buffer.Data)
-			refSumFloat := math.Float64frombits(bits)
+			result.ReferenceSum = refSumFloat
+			result.AbsDiff = math.Abs(sum - refSumFloat)
+			if refSumFloat != 0 {
+				result.RelDiff = result.AbsDiff / math.Abs(refSumFloat)
+			}
+			result.ULPDiff = ULPDistance(sum, refSumFloat)
+			result.Tolerance = opts.Tolerance
+			result.Passed = result.AbsDiff <= opts.Tolerance
 
-			fmt.Printf("\nValidation:\n")
-			fmt.Printf("Reference sum: %.16f\n", refSumFloat)
-			fmt.Printf("Difference   :    %.16f\n", sum-refSumFloat)
-			fmt.Printf("\n")
+			if opts.PairAnswersFileName != "" {
+				pairCheck, err := ValidatePairAnswers(opts.PairAnswersFileName, pairs[:pairCount], opts.MaxMismatches)
+				if err != nil {
+					return result, err
+				}
+				result.Pairs = &pairCheck
+			}
 
 		} else {
-			fmt.Fprintf(os.Stderr, "ERROR: Could not allocate memory for parsed values.\n")
-			os.Exit(1) // Fatal error, so exit
+			return result, errors.New("could not allocate memory for parsed values")
 		}
 	} else {
-		fmt.Fprintf(os.Stderr, "ERROR: Malformed input JSON\n")
-		os.Exit(1) // Fatal error, so exit
+		return result, fmt.Errorf("%w: input holds no pairs", shared.ErrMalformedInput)
 	}
 
+	return result, nil
+}
+
+// checkDataSet returns shared.ErrDataSetMismatch when the answer file records a
+// data set and input (with its binary header, if it is a binary pairs file) is
+// not it. Answer files older than version 2 can't be checked and always pass.
+// Hashing reads the whole input, so with checkHash false only the binary header
+// (and, later, the parsed pair count) is compared.
+func checkDataSet(answer shared.Answer, input []byte, header *shared.PairsHeader, checkHash bool) error {
+	if !answer.DescribesDataSet() {
+		return nil
+	}
+	describe := func(pairCount uint64, spread string, seed int64) string {
+		return fmt.Sprintf("%d %s pairs with seed %d", pairCount, spread, seed)
+	}
+	expected := describe(answer.PairCount, answer.Spread, answer.Seed)
+
+	expectedHash := answer.JSONHash
+	if header != nil {
+		if header.PairCount != answer.PairCount || header.Spread != answer.Spread || header.Seed != answer.Seed {
+			return fmt.Errorf("%w: input holds %s, the answer file is for %s",
+				shared.ErrDataSetMismatch, describe(header.PairCount, header.Spread, header.Seed), expected)
+		}
+		// Generated without a binary file, so there is no hash to compare
+		if answer.BinaryHash == 0 {
+			return nil
+		}
+		expectedHash = answer.BinaryHash
+	}
+	if !checkHash {
+		return nil
+	}
+
+	if hash := shared.HashInput(input); hash != expectedHash {
+		return fmt.Errorf("%w: input hash %016x, the answer file is for %s with hash %016x",
+			shared.ErrDataSetMismatch, hash, expected, expectedHash)
+	}
+	return nil
+}
+
+// ULPDistance is the number of representable float64s between a and b.
+func ULPDistance(a float64, b float64) uint64 {
+	ia, ib := orderedBits(a), orderedBits(b)
+	if ia > ib {
+		return uint64(ia) - uint64(ib)
+	}
+	return uint64(ib) - uint64(ia)
+}
+
+// orderedBits maps a float64 to an int64 that sorts the same way, so adjacent
+// floats differ by one.
+func orderedBits(f float64) int64 {
+	bits := int64(math.Float64bits(f))
+	if bits < 0 {
+		bits = math.MinInt64 - bits
+	}
+	return bits
+}
+
+// PairCheck is the outcome of comparing every pair against the per-pair answers.
+type PairCheck struct {
+	ReferenceCount int
+	MismatchCount  int
+	Mismatches     []PairMismatch // the first MaxMismatches of them
+}
+
+// PairMismatch is a pair whose recomputed distance differs from the reference.
+type PairMismatch struct {
+	Index     int
+	Pair      shared.HaversinePair
+	Distance  float64
+	Reference float64
+}
+
+// ValidatePairAnswers recomputes every pair's distance and collects the first
+// maxMismatches pairs that don't match the per-pair reference file.
+func ValidatePairAnswers(pairAnswersFileName string, pairs []shared.HaversinePair, maxMismatches int) (PairCheck, error) {
+	var check PairCheck
+	pairAnswersBuffer, err := shared.ReadEntireFile(pairAnswersFileName)
+	if err != nil {
+		return check, fmt.Errorf("reading pair answers file: %w", err)
+	}
+	defer shared.FreeBuffer(&pairAnswersBuffer)
+
+	f64Size := int64(unsafe.Sizeof(float64(0)))
+	refCount := pairAnswersBuffer.Count/f64Size - 1 // last value is the average
+	if pairAnswersBuffer.Count%f64Size != 0 || refCount != int64(len(pairs)) {
+		return check, fmt.Errorf("%w: pair answers file holds %d pairs, parsed %d", shared.ErrAnswerSize, refCount, len(pairs))
+	}
+
+	check.ReferenceCount = int(refCount)
+	for pairIndex, pair := range pairs {
+		bits := binary.LittleEndian.Uint64(pairAnswersBuffer.Data[int64(pairIndex)*f64Size:])
+		reference := math.Float64frombits(bits)
+		dist := shared.Haversine(pair)
+		if dist != reference {
+			check.MismatchCount++
+			if len(check.Mismatches) < maxMismatches {
+				check.Mismatches = append(check.Mismatches, PairMismatch{Index: pairIndex, Pair: pair, Distance: dist, Reference: reference})
+			}
+		}
+	}
+	return check, nil
 }
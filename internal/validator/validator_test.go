@@ -0,0 +1,87 @@
+package validator
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/ryank157/perfAware/internal/generator"
+	"github.com/ryank157/perfAware/internal/shared"
+)
+
+func generateDataSet(t *testing.T, pairCount int) generator.OutputPaths {
+	t.Helper()
+	paths := generator.OutputPathsFor(t.TempDir(), "pairs", true)
+	files := make([]*os.File, 4)
+	for i, path := range []string{paths.Data, paths.Answer, paths.PairAnswers, paths.Binary} {
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		files[i] = file
+	}
+	if _, err := generator.GenerateDataSet("cluster", 7, pairCount, shared.SumNaive, files[0], files[1], files[2], files[3]); err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestGeneratedDataSetHasNoPairMismatches(t *testing.T) {
+	paths := generateDataSet(t, 20000)
+
+	for _, input := range []string{paths.Data, paths.Binary} {
+		opts := Options{Float: FloatStrconv, PairAnswersFileName: paths.PairAnswers, MaxMismatches: 1, Tolerance: 1e-6}
+		result, err := ValidateData(input, paths.Answer, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if !result.Passed || result.Pairs == nil || result.Pairs.MismatchCount != 0 {
+			t.Errorf("%s: passed %v, pair check %+v", input, result.Passed, result.Pairs)
+		}
+	}
+}
+
+func TestSkipHash(t *testing.T) {
+	paths := generateDataSet(t, 100)
+	data, err := os.ReadFile(paths.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same pair count, different bytes: only the hash can tell
+	point := bytes.IndexByte(data, '.')
+	digit := point + bytes.IndexAny(data[point:], "123456789")
+	data[digit] = '0'
+	if err := os.WriteFile(paths.Data, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateData(paths.Data, paths.Answer, Options{}); !errors.Is(err, shared.ErrDataSetMismatch) {
+		t.Errorf("hashed: got %v, want ErrDataSetMismatch", err)
+	}
+	if _, err := ValidateData(paths.Data, paths.Answer, Options{SkipHash: true}); err != nil {
+		t.Errorf("skip hash: %v", err)
+	}
+}
+
+func TestArenaReusedAcrossRuns(t *testing.T) {
+	paths := generateDataSet(t, 1000)
+	opts := Options{Alloc: AllocArena, Arena: NewElementArena(64), Tolerance: 1e-6}
+
+	var capacity int
+	for run := range 3 {
+		result, err := ValidateData(paths.Data, paths.Answer, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Passed || result.PairCount != 1000 {
+			t.Errorf("run %d: passed %v with %d pairs", run, result.Passed, result.PairCount)
+		}
+		if run == 0 {
+			capacity = opts.Arena.Cap()
+		} else if opts.Arena.Cap() != capacity {
+			t.Errorf("run %d: arena grew from %d to %d elements", run, capacity, opts.Arena.Cap())
+		}
+	}
+}